@@ -0,0 +1,69 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/telemetry/internal/telemetry"
+	"golang.org/x/telemetry/internal/upload"
+)
+
+func runExport(_ []string) {
+	var rcfg upload.RunConfig
+	if exportConfig != "" {
+		data, err := os.ReadFile(exportConfig)
+		if err != nil {
+			failf("Reading upload config: %v\n", err)
+		}
+		var cfg telemetry.UploadConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			failf("Parsing upload config %s: %v\n", exportConfig, err)
+		}
+		rcfg.Config = &cfg
+		rcfg.ConfigVersion = exportConfigV
+	}
+	names, err := upload.Export(rcfg, exportOutput)
+	if err != nil {
+		failf("Export failed: %v\n", err)
+	}
+	if len(names) == 0 {
+		fmt.Println("No reports to export.")
+		return
+	}
+	for _, name := range names {
+		fmt.Printf("Exported %s\n", name)
+	}
+	fmt.Printf("Wrote %d reports to %s.\n", len(names), exportOutput)
+}
+
+func runImportUpload(args []string) {
+	if len(args) != 1 {
+		failf("usage: gotelemetry import-upload bundle\n")
+	}
+	results, err := upload.Import(upload.RunConfig{}, args[0])
+	if err != nil {
+		failf("Import failed: %v\n", err)
+	}
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Printf("%s: upload failed: %v\n", r.Name, r.Err)
+			failed++
+		case r.Skipped:
+			fmt.Printf("%s: already uploaded\n", r.Name)
+		case r.NoDest:
+			fmt.Printf("%s: skipped: destination reports are uploaded only by the program that configures the destination\n", r.Name)
+		default:
+			fmt.Printf("%s: uploaded\n", r.Name)
+		}
+	}
+	if failed > 0 {
+		failf("%d of %d reports failed to upload.\n", failed, len(results))
+	}
+}
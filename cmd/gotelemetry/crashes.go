@@ -0,0 +1,170 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/crashmonitor"
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+func runCrashes(args []string) {
+	entries, err := crashmonitor.ReadJournal(telemetry.Default)
+	if err != nil {
+		failf("Failed to read crash reports: %v\n", err)
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			failf("usage: gotelemetry crashes list\n")
+		}
+		if len(entries) == 0 {
+			fmt.Println("No saved crash reports.")
+			return
+		}
+		counts := localCounts()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tLAST CRASH\tKIND\tSAVED\tCOUNTER VALUE\tCOUNTER")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n",
+				e.ID, e.Time.Local().Format("2006-01-02 15:04"), e.Kind, e.Count, counts[counter.DecodeStack(e.Counter)], counterSummary(e.Counter))
+		}
+		tw.Flush()
+
+	case "show":
+		if len(args) != 2 {
+			failf("usage: gotelemetry crashes show id\n")
+		}
+		e := findCrash(entries, args[1])
+		fmt.Printf("ID: %s\n", e.ID)
+		fmt.Printf("Last crash: %s\n", e.Time.Local().Format("2006-01-02 15:04:05"))
+		fmt.Printf("Kind: %s\n", e.Kind)
+		fmt.Printf("Crashes saved: %d\n", e.Count)
+		fmt.Printf("Counter value: %d\n", localCounts()[counter.DecodeStack(e.Counter)])
+		fmt.Printf("Counter:\n\t%s\n", strings.ReplaceAll(counter.DecodeStack(e.Counter), "\n", "\n\t"))
+		fmt.Printf("\n%s\n", strings.TrimRight(e.Report, "\n"))
+
+	case "delete":
+		if len(args) < 2 {
+			failf("usage: gotelemetry crashes delete id... | all\n")
+		}
+		var ids []string
+		if len(args) == 2 && args[1] == "all" {
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+		} else {
+			for _, id := range args[1:] {
+				ids = append(ids, findCrash(entries, id).ID)
+			}
+		}
+		for _, id := range ids {
+			if err := crashmonitor.RemoveJournalEntry(telemetry.Default, id); err != nil {
+				warnf("failed to delete crash report %s: %v", id, err)
+			}
+		}
+
+	case "symbolize":
+		if len(args) < 3 {
+			failf("usage: gotelemetry crashes symbolize program file...\n")
+		}
+		exe := args[1]
+		for _, file := range args[2:] {
+			crash, err := os.ReadFile(file)
+			if err != nil {
+				failf("%v\n", err)
+			}
+			name, err := crashmonitor.SymbolizeCrash(exe, crash)
+			if err != nil {
+				failf("%s: %v\n", file, err)
+			}
+			fmt.Printf("-- %s --\n%s\n", file, counter.DecodeStack(name))
+		}
+
+	case "record":
+		if len(args) != 3 {
+			failf("usage: gotelemetry crashes record program file\n")
+		}
+		crash, err := os.ReadFile(args[2])
+		if err != nil {
+			failf("%v\n", err)
+		}
+		if err := recordCrash(args[1], crash); err != nil {
+			failf("%s: %v\n", args[2], err)
+		}
+
+	default:
+		failf("unknown crashes subcommand %q (see \"gotelemetry help crashes\")\n", args[0])
+	}
+}
+
+// findCrash returns the entry whose ID has the given prefix, failing if
+// there is not exactly one.
+func findCrash(entries []*crashmonitor.JournalEntry, id string) *crashmonitor.JournalEntry {
+	var found *crashmonitor.JournalEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.ID, id) {
+			if found != nil {
+				failf("crash ID %q is ambiguous\n", id)
+			}
+			found = e
+		}
+	}
+	if found == nil {
+		failf("no crash report with ID %q\n", id)
+	}
+	return found
+}
+
+// counterSummary returns a one-line summary of a crash counter name: its
+// first frame below the runtime's panic machinery, if any.
+func counterSummary(name string) string {
+	lines := strings.Split(counter.DecodeStack(name), "\n")
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, "runtime.") {
+			return lines[0] + " " + line
+		}
+	}
+	return lines[0]
+}
+
+// localCounts returns the values of all counters in the local counter
+// files, summed across files, keyed by their decoded names (see
+// [counter.DecodeStack]).
+func localCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	localdir := telemetry.Default.LocalDir()
+	fis, err := os.ReadDir(localdir)
+	if err != nil {
+		return counts
+	}
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".count") {
+			continue
+		}
+		file := filepath.Join(localdir, fi.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		f, err := counter.Parse(file, data)
+		if err != nil {
+			continue
+		}
+		for name, v := range f.Count {
+			counts[name] += v
+		}
+	}
+	return counts
+}
@@ -15,8 +15,14 @@
 //	on	enable telemetry collection and uploading
 //	local	enable telemetry collection but disable uploading
 //	off	disable telemetry collection and uploading
+//	review	review reports before they are uploaded
 //	view	run a web viewer for local telemetry data
 //	env	print the current telemetry environment
+//	crashes	inspect saved crash reports
+//	monitor	run a program, reporting its crashes to telemetry
+//	export	package upload reports for offline transfer
+//	import-upload	upload the reports of an exported bundle
+//	flush	report and upload the counters collected so far
 //	clean	remove all local telemetry data
 //
 // Use "gotelemetry help <command>" for details about any command.
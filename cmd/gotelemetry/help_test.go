@@ -21,6 +21,9 @@ import (
 var updateDocs = flag.Bool("update", false, "if set, update docs")
 
 func TestMain(m *testing.M) {
+	if len(os.Args) == 2 && os.Args[1] == "crash-on-signal" {
+		crashOnSignal() // see TestMonitorSignal
+	}
 	if os.Getenv("GOTELEMETRY_RUN_AS_MAIN") != "" {
 		main()
 		os.Exit(0)
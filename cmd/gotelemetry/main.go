@@ -8,12 +8,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/telemetry/cmd/gotelemetry/internal/csv"
 	"golang.org/x/telemetry/cmd/gotelemetry/internal/view"
@@ -39,6 +42,14 @@ func (c command) name() string {
 var (
 	viewFlags      = flag.NewFlagSet("view", flag.ExitOnError)
 	viewServer     view.Server
+	exportFlags    = flag.NewFlagSet("export", flag.ExitOnError)
+	exportOutput   string
+	exportConfig   string
+	exportConfigV  string
+	uploadFlags    = flag.NewFlagSet("upload", flag.ExitOnError)
+	uploadDryRun   bool
+	uploadURL      string
+	uploadSpoolDir string
 	normalCommands = []*command{
 		{
 			usage: "on",
@@ -77,6 +88,28 @@ To enable local collection (but not uploading) of telemetry data, run “gotelem
 To enable both collection and uploading, run “gotelemetry on”.`,
 			run: runOff,
 		},
+		{
+			usage: "review [on | list | show id | approve id... | approve all | reject id... | reject all]",
+			short: "review reports before they are uploaded",
+			long: `Gotelemetry review manages the reports that are held for review.
+
+"on" sets the telemetry mode to review. In review mode, telemetry data is
+collected as in the on mode, but each weekly report is held until it is
+approved, and only approved reports are uploaded.
+
+With no arguments, or with "list", gotelemetry review lists the reports held
+for review, and the number of rejected reports.
+
+"show id" prints the report with the given ID, as a diff against the local
+report from which it was built: lines starting with "-" are not uploaded.
+
+"approve id..." approves the given reports, which are uploaded by the next
+upload, and "reject id..." rejects them: they are never uploaded, but are
+kept in the local telemetry directory. "all" approves or rejects all the
+reports held for review.`,
+			run:     runReview,
+			hasArgs: true,
+		},
 		{
 			usage: "view [flags]",
 			short: "run a web viewer for local telemetry data",
@@ -89,12 +122,115 @@ This viewer displays charts for locally collected data, as well as information a
 		{
 			usage: "env",
 			short: "print the current telemetry environment",
-			run:   runEnv,
+			long: `Gotelemetry env prints the telemetry mode, the telemetry directories, and
+the status of the last upload: when it was attempted, when a report was
+last uploaded, why the last upload failed, if it did, the version of the
+upload config, and the number of reports left to upload.`,
+			run: runEnv,
+		},
+		{
+			usage: "crashes [list | show id | delete id... | delete all | symbolize program file... | record program file]",
+			short: "inspect saved crash reports",
+			long: `Gotelemetry crashes lists, shows, or deletes the crash reports saved by
+programs that report crashes to telemetry.
+
+When telemetry is enabled, either locally or with uploading, each crash of a
+monitored program increments a crash counter, and a copy of its traceback,
+with argument values and environment variables removed, is saved locally.
+Saved reports are never uploaded.
+
+With no arguments, or with "list", gotelemetry crashes lists the saved
+reports. Each report is identified by an ID, and is linked to the counter
+that the crash incremented, along with the value of that counter in the
+local counter files.
+
+"show id" prints the crash report with the given ID (or unique ID prefix).
+"delete id..." deletes the given reports, and "delete all" deletes all of
+them.
+
+"symbolize program file..." prints the crash counter name for each crash
+report file, such as a .crash file saved when a crash could not be
+recorded, printed with GOTRACEBACK=system by the given program. The
+program's symbol table is used to symbolize the traceback offline.
+"record program file" also records the crash in the program's counters.`,
+			run:     runCrashes,
+			hasArgs: true,
+		},
+		{
+			usage: "monitor [--] program [arguments]",
+			short: "run a program, reporting its crashes to telemetry",
+			long: `Gotelemetry monitor runs the given Go program with the given arguments,
+and records any fatal crash of the program in its telemetry counters, as if
+the program itself had enabled crash reporting.
+
+The program is run with GOTRACEBACK=system. Its standard error is passed
+through, and its exit status is that of gotelemetry monitor. Interrupt and
+termination signals sent to gotelemetry monitor are forwarded to the program,
+so that a crash they cause is still recorded. If the program crashes, its
+traceback is symbolized using the symbol table of the program's executable,
+and counted under the program's name and version.
+
+As with crash reporting by the program itself, nothing is recorded when
+telemetry is off.`,
+			run:     runMonitor,
+			hasArgs: true,
+		},
+		{
+			usage: "export [flags]",
+			short: "package upload reports for offline transfer",
+			long: `Gotelemetry export packages the reports that are ready for upload into a
+bundle file, for machines that cannot reach the upload server. The bundle is
+a gzipped tar file holding the reports, and a manifest with their checksums.
+
+Export first builds the reports of completed weeks, as automatic uploading
+does, using the upload configuration cached by the last upload from this
+machine, or the configuration in the file given by the -config flag. If no
+configuration is available, only the reports built earlier are exported.
+
+Exported reports are marked as uploaded, so that they are never uploaded
+from this machine. Reports are ready for upload only when telemetry
+uploading is enabled. Only the reports for the Go telemetry upload server
+are exported: the reports for the additional destinations configured by a
+program are left for that program to upload.
+
+To upload the reports of a bundle from a connected machine, run
+“gotelemetry import-upload bundle”.`,
+			flags: exportFlags,
+			run:   runExport,
+		},
+		{
+			usage: "import-upload bundle",
+			short: "upload the reports of an exported bundle",
+			long: `Gotelemetry import-upload checks the given bundle, created by “gotelemetry
+export”, and uploads each of its reports, as automatic uploading does.
+
+If the bundle is incomplete or corrupted, nothing is uploaded. The reports
+that were uploaded are recorded in the local telemetry directory, so that
+importing a bundle again uploads only the reports that failed to upload.
+Reports for the additional destinations configured by a program, in bundles
+exported by that program, are skipped.`,
+			run:     runImportUpload,
+			hasArgs: true,
+		},
+		{
+			usage: "flush",
+			short: "report and upload the counters collected so far",
+			long: `Gotelemetry flush makes the current counter files expire now, and runs the
+upload process, so that their counters are reported in a partial report
+rather than at the end of the week. It is meant for short-lived machines,
+such as CI runners, that would otherwise never report their counters.
+
+Counter files that are in use by programs that are still running are not
+flushed, so flush once the programs of interest have exited. At most one
+report is flushed each day. As with automatic uploading, the report is
+uploaded only when telemetry uploading is enabled.`,
+			run: runFlush,
 		},
 		{
 			usage: "clean",
 			short: "remove all local telemetry data",
-			long: `Gotelemetry clean removes locally collected counters and reports.
+			long: `Gotelemetry clean removes locally collected counters, reports, and saved
+crash reports.
 
 Removing counter files that are currently in use may fail on some operating
 systems.
@@ -116,8 +252,20 @@ Gotelemetry clean does not affect the current telemetry mode.`,
 			hasArgs: true,
 		},
 		{
-			usage: "upload",
+			usage: "upload [flags]",
 			short: "run upload with logging enabled",
+			long: `Gotelemetry upload runs the upload process with logging enabled.
+
+With -n, gotelemetry upload builds the reports that would be uploaded without
+writing, deleting or uploading anything, and prints each of them, along with
+whether each counter is kept or dropped, and why. The reports are built as if
+uploading were enabled, whatever the current telemetry mode.
+
+With -spool, gotelemetry upload writes each report to a file in the given
+directory instead of uploading it, for machines that cannot reach the upload
+server. The reports may then be carried to a connected machine and uploaded
+from there.`,
+			flags: uploadFlags,
 			run:   runUpload,
 		},
 	}
@@ -128,6 +276,12 @@ func init() {
 	viewFlags.BoolVar(&viewServer.Dev, "dev", false, "rebuild static assets on save")
 	viewFlags.StringVar(&viewServer.FsConfig, "config", "", "load a config from the filesystem")
 	viewFlags.BoolVar(&viewServer.Open, "open", true, "open the browser to the server address")
+	exportFlags.StringVar(&exportOutput, "o", "telemetry-reports.tar.gz", "write the bundle to the given file")
+	exportFlags.StringVar(&exportConfig, "config", "", "build reports using the upload configuration in the given JSON file")
+	exportFlags.StringVar(&exportConfigV, "config-version", "v0.0.0", "record the given version of the -config file in reports")
+	uploadFlags.BoolVar(&uploadDryRun, "n", false, "print the reports that would be uploaded, without uploading")
+	uploadFlags.StringVar(&uploadURL, "url", upload.DefaultUploadURL, "upload reports to the given endpoint")
+	uploadFlags.StringVar(&uploadSpoolDir, "spool", "", "write reports to the given directory instead of uploading them")
 
 	for _, cmd := range append(normalCommands, experimentalCommands...) {
 		name := cmd.name()
@@ -269,6 +423,30 @@ func runEnv(_ []string) {
 	fmt.Println("modefile:", telemetry.Default.ModeFile())
 	fmt.Println("localdir:", telemetry.Default.LocalDir())
 	fmt.Println("uploaddir:", telemetry.Default.UploadDir())
+	fmt.Println()
+	status, err := upload.ReadStatus(upload.RunConfig{})
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Println("upload status: no upload attempted")
+	case err != nil:
+		fmt.Println("upload status:", err)
+	default:
+		fmt.Println("last upload attempt:", formatTime(status.LastAttempt))
+		fmt.Println("last successful upload:", formatTime(status.LastSuccess))
+		if status.LastError != "" {
+			fmt.Println("last upload error:", status.LastError)
+		}
+		fmt.Println("upload config:", status.Config)
+		fmt.Println("pending reports:", status.Pending)
+	}
+}
+
+// formatTime formats t for display, or returns "never" if t is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC1123)
 }
 
 func runClean(_ []string) {
@@ -276,8 +454,11 @@ func runClean(_ []string) {
 	// It would probably be OK to just remove everything, but it may
 	// be useful to preserve the weekends file.
 	for dir, suffixes := range map[string][]string{
-		telemetry.Default.LocalDir():  {"." + counter.FileVersion + ".count", ".json"},
-		telemetry.Default.UploadDir(): {".json"},
+		telemetry.Default.LocalDir():                            {"." + counter.FileVersion + ".count", ".json"},
+		telemetry.Default.UploadDir():                           {".json"},
+		filepath.Join(telemetry.Default.LocalDir(), "pending"):  {".json"},
+		filepath.Join(telemetry.Default.LocalDir(), "rejected"): {".json"},
+		telemetry.Default.CrashDir():                            {".json", ".state", ".tmp"},
 	} {
 		entries, err := os.ReadDir(dir)
 		if err != nil {
@@ -345,15 +526,80 @@ func runDump(args []string) {
 }
 
 func runUpload(_ []string) {
-	if err := upload.Run(upload.RunConfig{
+	if uploadDryRun {
+		runDryUpload()
+		return
+	}
+	rcfg := upload.RunConfig{
+		UploadURL: uploadURL,
 		LogWriter: os.Stderr,
-	}); err != nil {
+	}
+	if uploadSpoolDir != "" {
+		rcfg.Transport = &upload.DirTransport{Dir: uploadSpoolDir}
+	}
+	if err := upload.Run(rcfg); err != nil {
 		fmt.Printf("Upload failed: %v\n", err)
 	} else {
 		fmt.Println("Upload completed.")
 	}
 }
 
+func runFlush(_ []string) {
+	if mode, _ := telemetry.Default.Mode(); mode == "off" {
+		fmt.Println("Telemetry is off: there are no counters to flush.")
+		return
+	}
+	if err := upload.Run(upload.RunConfig{
+		Flush: true,
+	}); err != nil {
+		failf("Flush failed: %v\n", err)
+	}
+	fmt.Println("Flush completed.")
+}
+
+func runDryUpload() {
+	if mode, _ := telemetry.Default.Mode(); !upload.UploadsEnabled(mode) {
+		fmt.Printf("Telemetry mode is %s: showing the reports that would be uploaded if it were on.\n", mode)
+	}
+	n := 0
+	err := upload.Run(upload.RunConfig{
+		DryRun: func(r *upload.DryRunReport) {
+			n++
+			dest := "telemetry.go.dev"
+			if r.Destination != "" {
+				dest = r.Destination
+			}
+			switch {
+			case r.Ready:
+				fmt.Printf("-- report for %s to %s (ready) --\n", r.Week, dest)
+			case r.Report == nil:
+				fmt.Printf("-- report for %s to %s (not uploaded) --\n", r.Week, dest)
+			default:
+				fmt.Printf("-- report for %s to %s --\n", r.Week, dest)
+			}
+			if r.Report != nil {
+				js, err := json.MarshalIndent(r.Report, "", "\t")
+				if err != nil {
+					failf("%v\n", err)
+				}
+				fmt.Printf("%s\n", js)
+			}
+			if len(r.Counters) > 0 {
+				fmt.Println("-- counters --")
+				for _, c := range r.Counters {
+					fmt.Println(c)
+				}
+			}
+		},
+	})
+	if err != nil {
+		failf("Dry run failed: %v\n", err)
+	}
+	if n == 0 {
+		fmt.Println("No reports would be uploaded.")
+	}
+}
+
 func main() {
 	log.SetFlags(0)
 	flag.Usage = usage
@@ -0,0 +1,159 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/crashmonitor"
+)
+
+// maxMonitoredStderr is the amount of the monitored program's most recent
+// standard error output that is retained for parsing a crash.
+const maxMonitoredStderr = 4 << 20
+
+func runMonitor(args []string) {
+	if len(args) == 0 {
+		failf("usage: gotelemetry monitor [--] program [arguments]\n")
+	}
+	exe, err := exec.LookPath(args[0])
+	if err != nil {
+		failf("%v\n", err)
+	}
+
+	stderr := &tailBuffer{max: maxMonitoredStderr}
+	cmd := exec.Command(exe, args[1:]...)
+	cmd.Env = append(os.Environ(), "GOTRACEBACK=system")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	// Forward termination signals to the program, and outlive it, so as
+	// to observe its crash, if any. (Ignoring the signals instead would
+	// cause the program to ignore them too.)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	if err := cmd.Start(); err != nil {
+		failf("%v\n", err)
+	}
+	go func() {
+		for sig := range sigs {
+			cmd.Process.Signal(sig) // an error means the program has exited
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		failf("%v\n", err)
+	}
+	code := cmd.ProcessState.ExitCode()
+	// A fatal Go runtime error exits with status 2, after printing a
+	// traceback of all goroutines.
+	if crash := stderr.Bytes(); code == 2 && isCrash(crash) {
+		// Only the crash itself is reported: strip preceding output.
+		if i := lastCrashStart(crash); i > 0 {
+			crash = crash[i:]
+		}
+		if err := recordCrash(exe, crash); err != nil {
+			warnf("not recording crash: %v", err)
+		}
+	}
+	if code < 0 { // killed by a signal
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// isCrash reports whether the standard error output of a program appears
+// to end with a Go runtime crash.
+func isCrash(stderr []byte) bool {
+	for _, prefix := range []string{"panic: ", "fatal error: ", "SIG"} {
+		if bytes.HasPrefix(stderr, []byte(prefix)) || bytes.Contains(stderr, []byte("\n"+prefix)) {
+			return bytes.Contains(stderr, []byte("\ngoroutine "))
+		}
+	}
+	return false
+}
+
+// recordCrash records the crash of the program exe in telemetry, using the
+// program's identity for the counter file.
+//
+// recordCrash must be called at most once per process.
+func recordCrash(exe string, crash []byte) error {
+	bi, err := buildinfo.ReadFile(exe)
+	if err != nil {
+		return fmt.Errorf("reading build information of %s: %v", exe, err)
+	}
+	counter.SetBuildInfo(bi)
+	counter.Open(false)
+	return crashmonitor.ReportExecutableCrash(exe, crash)
+}
+
+// lastCrashStart returns the offset of the start of the last crash message
+// in stderr, or -1 if there is none.
+func lastCrashStart(stderr []byte) int {
+	start := -1
+	for i := 0; i < len(stderr); {
+		line := stderr[i:]
+		if j := bytes.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		if s := string(line); strings.HasPrefix(s, "panic: ") || strings.HasPrefix(s, "fatal error: ") ||
+			strings.HasPrefix(s, "SIG") && strings.Contains(s, ": ") {
+			// A crash may begin with several such lines, as in a panic
+			// during a panic: keep the first of a run of them.
+			if start < 0 || bytes.Contains(stderr[start:i], []byte("\ngoroutine ")) {
+				start = i
+			}
+		}
+		i += len(line) + 1
+	}
+	return start
+}
+
+// A tailBuffer is an io.Writer that retains the last max bytes written,
+// in a ring buffer.
+type tailBuffer struct {
+	max  int
+	buf  []byte // at most max bytes
+	next int    // once buf is full, the offset of its oldest byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= b.max {
+		// Only the end of p is retained.
+		b.buf = append(b.buf[:0], p[len(p)-b.max:]...)
+		b.next = 0
+		return n, nil
+	}
+	if room := b.max - len(b.buf); room > 0 {
+		k := min(room, len(p))
+		b.buf = append(b.buf, p[:k]...)
+		p = p[k:]
+	}
+	for len(p) > 0 {
+		k := copy(b.buf[b.next:], p)
+		p = p[k:]
+		b.next = (b.next + k) % b.max
+	}
+	return n, nil
+}
+
+// Bytes returns the retained bytes, oldest first.
+func (b *tailBuffer) Bytes() []byte {
+	return append(b.buf[b.next:len(b.buf):len(b.buf)], b.buf[:b.next]...)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/testenv"
+)
+
+func TestTailBuffer(t *testing.T) {
+	const size = 10
+	b := &tailBuffer{max: size}
+	var all []byte
+	for i, n := range []int{3, 4, 5, 0, 9, 1, 10, 2, 23, 7, 7, 7} {
+		p := bytes.Repeat([]byte{byte('a' + i)}, n)
+		if got, err := b.Write(p); got != n || err != nil {
+			t.Fatalf("Write(%d bytes) = %d, %v", n, got, err)
+		}
+		all = append(all, p...)
+		want := all[max(len(all)-size, 0):]
+		if got := b.Bytes(); !bytes.Equal(got, want) {
+			t.Fatalf("after writing %q: Bytes() = %q, want %q", all, got, want)
+		}
+	}
+}
+
+// crashOnSignal is the program run by TestMonitorSignal: it crashes when
+// it receives a termination signal.
+func crashOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	fmt.Println("ready")
+	panic(fmt.Sprintf("received %v", <-sigs))
+}
+
+// TestMonitorSignal checks that gotelemetry monitor forwards a termination
+// signal to the program, and records the resulting crash.
+func TestMonitorSignal(t *testing.T) {
+	testenv.MustHaveExec(t)
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("SIGTERM is not supported on %s", runtime.GOOS)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDir := t.TempDir() // holds the telemetry directory
+	cmd := exec.Command(exe, "monitor", "--", exe, "crash-on-signal")
+	cmd.Env = append(os.Environ(), "GOTELEMETRY_RUN_AS_MAIN=1", "HOME="+configDir, "XDG_CONFIG_HOME="+configDir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatalf("reading the program's output: %v\n%s", err, &stderr)
+	}
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		cmd.Process.Kill()
+		t.Fatal("monitor did not exit after SIGTERM")
+	}
+	if got, want := cmd.ProcessState.ExitCode(), 2; got != want {
+		t.Fatalf("monitor exited with status %d, want %d (the crash's)\n%s", got, want, &stderr)
+	}
+
+	// Find the crash in the count files of the telemetry directory.
+	found := false
+	filepath.WalkDir(configDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !strings.HasSuffix(path, ".count") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := counter.Parse(path, data)
+		if err != nil {
+			return err
+		}
+		if f.Count["crash/kind:panic"] > 0 {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		t.Errorf("the crash was not recorded\n%s", &stderr)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/telemetry/internal/telemetry"
+	"golang.org/x/telemetry/internal/upload"
+)
+
+func runReview(args []string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	if args[0] == "on" {
+		if len(args) > 1 {
+			failf("usage: gotelemetry review on\n")
+		}
+		if err := telemetry.Default.SetMode("review"); err != nil {
+			failf("Failed to set the telemetry mode to review: %v\n", err)
+		}
+		fmt.Println("Telemetry reports are now held for review before uploading.")
+		fmt.Println("To review them, run “gotelemetry review”.")
+		return
+	}
+
+	review, err := upload.ReadReview(upload.RunConfig{})
+	if err != nil {
+		failf("Failed to read reports held for review: %v\n", err)
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			failf("usage: gotelemetry review list\n")
+		}
+		if mode, _ := telemetry.Default.Mode(); mode != "review" {
+			fmt.Printf("Telemetry mode is %s: new reports are not held for review.\n", mode)
+		}
+		if len(review.Pending) == 0 {
+			fmt.Println("No reports held for review.")
+		} else {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tPROGRAMS\tCOUNTERS")
+			for _, r := range review.Pending {
+				programs, counters := reportSize(r.Report)
+				fmt.Fprintf(tw, "%s\t%d\t%d", r.ID, programs, counters)
+				if r.Local != nil {
+					programs, counters := reportSize(r.Local)
+					fmt.Fprintf(tw, " (of %d in %d programs)", counters, programs)
+				}
+				fmt.Fprintln(tw)
+			}
+			tw.Flush()
+		}
+		if review.Rejected > 0 {
+			fmt.Printf("Rejected reports: %d\n", review.Rejected)
+		}
+
+	case "show":
+		if len(args) != 2 {
+			failf("usage: gotelemetry review show id\n")
+		}
+		r := findPending(review, args[1])
+		fmt.Print(diffReports(r.Local, r.Report))
+
+	case "approve", "reject":
+		if len(args) < 2 {
+			failf("usage: gotelemetry review %s id... | all\n", args[0])
+		}
+		var ids []string
+		if len(args) == 2 && args[1] == "all" {
+			for _, r := range review.Pending {
+				ids = append(ids, r.ID)
+			}
+		} else {
+			for _, id := range args[1:] {
+				ids = append(ids, findPending(review, id).ID)
+			}
+		}
+		act, done := upload.Approve, "Approved"
+		if args[0] == "reject" {
+			act, done = upload.Reject, "Rejected"
+		}
+		failed := false
+		for _, id := range ids {
+			if err := act(upload.RunConfig{}, id); err != nil {
+				warnf("failed to %s report %s: %v", args[0], id, err)
+				failed = true
+			} else {
+				fmt.Printf("%s %s\n", done, id)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	default:
+		failf("unknown review command %q\n", args[0])
+	}
+}
+
+// findPending returns the pending report with the given ID, or fails.
+func findPending(review *upload.Review, id string) *upload.PendingReport {
+	var found *upload.PendingReport
+	for _, r := range review.Pending {
+		if r.ID == id {
+			found = r
+		}
+	}
+	if found == nil {
+		failf("no report %q held for review\n", id)
+	}
+	return found
+}
+
+// reportSize returns the number of programs and counters of a report.
+func reportSize(r *telemetry.Report) (programs, counters int) {
+	for _, p := range r.Programs {
+		counters += len(p.Counters) + len(p.Stacks)
+	}
+	return len(r.Programs), counters
+}
+
+// diffReports returns a diff of a local report and the upload report
+// built from it: lines starting with "-" are in the local report only,
+// lines starting with "+" are in the upload report only.
+func diffReports(local, upload *telemetry.Report) string {
+	var b strings.Builder
+	line := func(op byte, format string, args ...any) {
+		for _, l := range strings.Split(fmt.Sprintf(format, args...), "\n") {
+			fmt.Fprintf(&b, "%c%s\n", op, l)
+		}
+	}
+	if local == nil {
+		local = new(telemetry.Report)
+		b.WriteString("(the local report is missing)\n")
+	}
+	fmt.Fprintf(&b, "--- local report %s\n+++ upload report %s\n", local.Week, upload.Week)
+	line(' ', "Week: %s", upload.Week)
+	line('+', "LastWeek: %s", upload.LastWeek)
+	line('+', "X: %v", upload.X)
+	line('+', "Config: %s", upload.Config)
+
+	key := func(p *telemetry.ProgramReport) string {
+		return fmt.Sprintf("%s@%s %s %s/%s", p.Program, p.Version, p.GoVersion, p.GOOS, p.GOARCH)
+	}
+	programs := make(map[string][2]*telemetry.ProgramReport)
+	for _, p := range local.Programs {
+		programs[key(p)] = [2]*telemetry.ProgramReport{p, nil}
+	}
+	for _, p := range upload.Programs {
+		pair := programs[key(p)]
+		pair[1] = p
+		programs[key(p)] = pair
+	}
+	var keys []string
+	for k := range programs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l, u := programs[k][0], programs[k][1]
+		switch {
+		case u == nil:
+			line('-', "program %s", k)
+		case l == nil:
+			line('+', "program %s", k)
+		default:
+			line(' ', "program %s", k)
+		}
+		var lc, uc map[string]int64
+		if l != nil {
+			lc = merge(l.Counters, l.Stacks)
+		}
+		if u != nil {
+			uc = merge(u.Counters, u.Stacks)
+		}
+		var names []string
+		for name := range lc {
+			names = append(names, name)
+		}
+		for name := range uc {
+			if _, ok := lc[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			display := strings.ReplaceAll(name, "\n", "\n\t\t") // indent stacks
+			lv, inLocal := lc[name]
+			uv, inUpload := uc[name]
+			switch {
+			case inLocal && inUpload && lv == uv:
+				line(' ', "\t%s %d", display, uv)
+			case inLocal && inUpload:
+				line('-', "\t%s %d", display, lv)
+				line('+', "\t%s %d", display, uv)
+			case inLocal:
+				line('-', "\t%s %d", display, lv)
+			default:
+				line('+', "\t%s %d", display, uv)
+			}
+		}
+	}
+	return b.String()
+}
+
+// merge returns the union of the counters and stack counters of a program.
+func merge(counters, stacks map[string]int64) map[string]int64 {
+	m := make(map[string]int64, len(counters)+len(stacks))
+	for k, v := range counters {
+		m[k] = v
+	}
+	for k, v := range stacks {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Telemetrysidecar is a small helper program that monitors crashes and
+// uploads telemetry on behalf of another program.
+//
+// It is not meant to be run directly: programs that call
+// [golang.org/x/telemetry.Start] with [golang.org/x/telemetry.Config.SidecarPath]
+// set to the path of this executable start it as their telemetry sidecar,
+// instead of re-executing themselves. The application's executable path
+// and build information are passed to the sidecar through its environment,
+// and are used to name counter files and symbolize crashes.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/telemetry"
+)
+
+func main() {
+	var (
+		config       telemetry.Config
+		uploadStart  string
+		destinations string
+	)
+	flag.StringVar(&config.TelemetryDir, "dir", "", "telemetry directory of the application")
+	flag.StringVar(&config.UploadURL, "upload-url", "", "if set, overrides the upload endpoint")
+	flag.StringVar(&config.UploadSpoolDir, "upload-spool-dir", "", "if set, write reports to this directory instead of uploading them")
+	flag.StringVar(&destinations, "upload-destinations", "", "if set, additional destinations of reports (JSON)")
+	flag.StringVar(&uploadStart, "upload-start", "", "if set, overrides the upload start time (RFC 3339)")
+	flag.DurationVar(&config.UploadTimeout, "upload-timeout", 0, "if set, limits the time spent uploading")
+	flag.DurationVar(&config.UploadConfigTimeout, "upload-config-timeout", 0, "if set, limits the upload config download")
+	flag.DurationVar(&config.UploadRequestTimeout, "upload-request-timeout", 0, "if set, limits each upload request")
+	flag.BoolVar(&config.ReportCrashes, "crashes", false, "monitor the application for crashes")
+	flag.Parse()
+
+	if os.Getenv("GO_TELEMETRY_CHILD") != "1" {
+		fmt.Fprintln(os.Stderr, "telemetrysidecar: must be started by telemetry.Start")
+		os.Exit(2)
+	}
+	if uploadStart != "" {
+		t, err := time.Parse(time.RFC3339, uploadStart)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "telemetrysidecar: invalid -upload-start: %v\n", err)
+			os.Exit(2)
+		}
+		config.UploadStartTime = t
+	}
+	if destinations != "" {
+		if err := json.Unmarshal([]byte(destinations), &config.UploadDestinations); err != nil {
+			fmt.Fprintf(os.Stderr, "telemetrysidecar: invalid -upload-destinations: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	telemetry.MaybeChild(config) // does not return
+}
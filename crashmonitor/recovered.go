@@ -0,0 +1,30 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+import ic "golang.org/x/telemetry/internal/crashmonitor"
+
+// ReportRecovered records a panic that the application recovered from, in
+// the same form as the crash/crash stack counters that record unrecovered
+// crashes, so that the two may be compared directly. The counter is named
+// crash/recovered, followed by the stack of the panicking goroutine,
+// starting at the call to panic.
+//
+// ReportRecovered must be called directly from the function deferred by the
+// panicking goroutine, with the result of recover:
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			crashmonitor.ReportRecovered(r)
+//			...
+//		}
+//	}()
+//
+// If r is nil, ReportRecovered does nothing.
+//
+// As with other counters, ReportRecovered has no effect unless the local
+// telemetry database has been opened, by [golang.org/x/telemetry.Start]
+// or [golang.org/x/telemetry/counter.Open].
+func ReportRecovered(r any) { ic.ReportRecovered(r) }
@@ -30,6 +30,7 @@ import (
 	"golang.org/x/telemetry/internal/chartconfig"
 	tconfig "golang.org/x/telemetry/internal/config"
 	contentfs "golang.org/x/telemetry/internal/content"
+	"golang.org/x/telemetry/internal/reportio"
 	"golang.org/x/telemetry/internal/telemetry"
 	"golang.org/x/telemetry/internal/unionfs"
 )
@@ -75,7 +76,7 @@ func newHandler(ctx context.Context, cfg *config.Config) http.Handler {
 	mux.Handle("/", handleRoot(render, fsys, buckets.Chart, logger))
 	mux.Handle("/config", handleConfig(fsys, ucfg))
 	// TODO(rfindley): restrict this routing to POST
-	mux.Handle("/upload/", handleUpload(ucfg, buckets.Upload))
+	mux.Handle("/upload/", handleUpload(ucfg, buckets.Upload, cfg.MaxRequestBytes))
 	mux.Handle("/charts/", handleCharts(render, buckets.Chart))
 	mux.Handle("/data/", handleData(render, buckets.Merge))
 
@@ -281,15 +282,25 @@ func loadCharts(ctx context.Context, chartObj string, bucket storage.BucketHandl
 	return charts, nil
 }
 
-func handleUpload(ucfg *tconfig.Config, uploadBucket storage.BucketHandle) content.HandlerFunc {
+// handleUpload handles report uploads. Reports may be gzip-compressed, in
+// which case maxRequestBytes limits the size of the decompressed report,
+// as the RequestSize middleware does for plain reports.
+func handleUpload(ucfg *tconfig.Config, uploadBucket storage.BucketHandle, maxRequestBytes int64) content.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		if r.Method == "POST" {
 			ctx := r.Context()
-			var report telemetry.Report
-			if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
-				return content.Error(fmt.Errorf("invalid JSON payload: %v", err), http.StatusBadRequest)
+			report, err := reportio.ReadReport(w, r, maxRequestBytes)
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				switch {
+				case errors.As(err, &maxErr):
+					return content.Error(err, http.StatusRequestEntityTooLarge)
+				case errors.Is(err, reportio.ErrUnsupportedEncoding):
+					return content.Error(err, http.StatusUnsupportedMediaType)
+				}
+				return content.Error(err, http.StatusBadRequest)
 			}
-			if err := validate(&report, ucfg); err != nil {
+			if err := validate(report, ucfg); err != nil {
 				return content.Error(fmt.Errorf("invalid report: %v", err), http.StatusBadRequest)
 			}
 			// TODO: capture metrics for collisions.
@@ -323,6 +334,10 @@ func validate(r *telemetry.Report, cfg *tconfig.Config) error {
 	if r.X == 0 {
 		return fmt.Errorf("invalid X %g", r.X)
 	}
+	// Partial reports were flushed before the end of their week.
+	if r.Partial < 0 || r.Partial >= 1 {
+		return fmt.Errorf("invalid Partial %g", r.Partial)
+	}
 	// TODO: We can probably keep known programs and counters even when a report
 	// includes something that has been removed from the latest config.
 	for _, p := range r.Programs {
@@ -175,6 +175,28 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid partial report",
+			report: &telemetry.Report{
+				Week:     "2023-06-15",
+				X:        0.1,
+				Programs: []*telemetry.ProgramReport{},
+				Config:   "v0.0.1-test",
+				Partial:  0.25,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid partial report",
+			report: &telemetry.Report{
+				Week:     "2023-06-15",
+				X:        0.1,
+				Programs: []*telemetry.ProgramReport{},
+				Config:   "v0.0.1-test",
+				Partial:  1.5,
+			},
+			wantErr: true,
+		},
 		{
 			name: "valid report with counters",
 			report: &telemetry.Report{
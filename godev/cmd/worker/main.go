@@ -14,9 +14,11 @@ import (
 	"go/version"
 	"io/fs"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -332,6 +334,7 @@ func handleChart(cfg *tconfig.Config, s *storage.API) content.HandlerFunc {
 
 		var reports []telemetry.Report
 		var xs []float64
+		weights := make(map[reportID]float64)
 		for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
 			dailyReports, err := readMergedReports(ctx, date.Format(telemetry.DateOnly)+".json", s)
 			if err != nil {
@@ -340,11 +343,15 @@ func handleChart(cfg *tconfig.Config, s *storage.API) content.HandlerFunc {
 			for _, r := range dailyReports {
 				reports = append(reports, r)
 				xs = append(xs, r.X)
+				if r.Partial > 0 {
+					weights[reportID(r.X)] = r.Partial
+				}
 			}
 		}
 
 		data := group(reports)
-		charts := charts(cfg, start.Format(telemetry.DateOnly), end.Format(telemetry.DateOnly), data, xs)
+		noisy := groupNoisy(reports)
+		charts := charts(cfg, start.Format(telemetry.DateOnly), end.Format(telemetry.DateOnly), data, noisy, weights, xs)
 
 		obj := fileName(start, end)
 		out, err := s.Chart.Object(obj).NewWriter(ctx)
@@ -395,7 +402,10 @@ type datum struct {
 	Value float64
 }
 
-func charts(cfg *tconfig.Config, start, end string, d data, xs []float64) *chartdata {
+// charts builds the charts of the programs of cfg from the data d, and
+// the noisy data nd. Partial reports count in charts for the fraction of a
+// week they cover, given by weights; other reports count for one.
+func charts(cfg *tconfig.Config, start, end string, d data, nd noisyData, weights map[reportID]float64, xs []float64) *chartdata {
 	result := &chartdata{DateRange: [2]string{start, end}, NumReports: len(xs)}
 	for _, p := range cfg.Programs {
 		prog := &program{ID: "charts:" + p.Name, Name: p.Name}
@@ -407,11 +417,12 @@ func charts(cfg *tconfig.Config, start, end string, d data, xs []float64) *chart
 				ignoreEmptyBuckets: true,
 				// Don't normalize buckets: we want to see counts for all versions.
 				compareBuckets: compareSemver,
+				weights:        weights,
 			}))
 		}
 		charts = append(charts,
-			d.partition(program, goosCounter, toSliceOf[bucketName](cfg.GOOS), partitionOptions{}),
-			d.partition(program, goarchCounter, toSliceOf[bucketName](cfg.GOARCH), partitionOptions{}),
+			d.partition(program, goosCounter, toSliceOf[bucketName](cfg.GOOS), partitionOptions{weights: weights}),
+			d.partition(program, goarchCounter, toSliceOf[bucketName](cfg.GOARCH), partitionOptions{weights: weights}),
 			d.partition(program, goversionCounter, toSliceOf[bucketName](cfg.GoVersion), partitionOptions{
 				ignoreEmptyBuckets: true,
 				normalizeBucket: func(b bucketName) bucketName {
@@ -419,6 +430,7 @@ func charts(cfg *tconfig.Config, start, end string, d data, xs []float64) *chart
 					return bucketName(goMajorMinor(string(b)))
 				},
 				compareBuckets: version.Compare,
+				weights:        weights,
 			}))
 		for _, c := range p.Counters {
 			// TODO: add support for histogram counters by getting the counter type
@@ -429,7 +441,13 @@ func charts(cfg *tconfig.Config, start, end string, d data, xs []float64) *chart
 				_, bucket := splitCounterName(counter)
 				buckets = append(buckets, bucket)
 			}
-			charts = append(charts, d.partition(program, chart, buckets, partitionOptions{}))
+			opts := partitionOptions{weights: weights}
+			// Noisy data can only be corrected for with the chart's
+			// epsilon; without it, only the exact data is charted.
+			if c.Epsilon > 0 {
+				opts.epsilon, opts.noisy = c.Epsilon, nd
+			}
+			charts = append(charts, d.partition(program, chart, buckets, opts))
 		}
 		for _, p := range charts {
 			if p != nil {
@@ -483,6 +501,35 @@ type partitionOptions struct {
 	// compareBuckets returns -1, 0, or +1 if x < y, x == y, or x > y.
 	// Otherwise, buckets are sorted lexically.
 	compareBuckets func(x, y string) int
+
+	// If epsilon is positive, noisy holds the responses of program reports
+	// that reported the buckets with randomized response using this
+	// privacy parameter (see [tconfig.TruthProbability]). The number of
+	// these reports in each bucket is estimated from the responses, and
+	// added to the exact number of the other reports.
+	epsilon float64
+	noisy   noisyData
+
+	// If weights is provided, it holds the weight of reports that do not
+	// count for one, such as partial reports.
+	weights map[reportID]float64
+}
+
+// count returns the weighted number of the given reports.
+func (opts partitionOptions) count(ids map[reportID]struct{}) float64 {
+	n := 0.0
+	for id := range ids {
+		n += opts.weight(id)
+	}
+	return n
+}
+
+// weight returns the weight of the given report.
+func (opts partitionOptions) weight(id reportID) float64 {
+	if w, ok := opts.weights[id]; ok {
+		return w
+	}
+	return 1
 }
 
 // partition builds a chart for the program and the counter. It can return nil
@@ -496,10 +543,18 @@ func (d data) partition(program programName, chartName graphName, buckets []buck
 	pk := programName(program)
 
 	var (
-		merged = make(map[bucketName]map[reportID]struct{}) // normalized bucket name -> merged report IDs
-		empty  = true                                       // keep track of empty reports, so they can be skipped
-		end    weekName                                     // latest week observed
+		merged    = make(map[bucketName]map[reportID]struct{}) // normalized bucket name -> merged report IDs
+		noisy     = make(map[bucketName]float64)               // normalized bucket name -> weighted number of noisy responses
+		responses float64                                      // weighted number of noisy responses
+		empty     = true                                       // keep track of empty reports, so they can be skipped
+		end       weekName                                     // latest week observed
 	)
+	normalize := func(bucket bucketName) bucketName {
+		if opts.normalizeBucket != nil {
+			return opts.normalizeBucket(bucket)
+		}
+		return bucket
+	}
 	for wk := range d {
 		if wk >= end {
 			end = wk
@@ -512,10 +567,7 @@ func (d data) partition(program programName, chartName graphName, buckets []buck
 				continue
 			}
 			seen[bucket] = true
-			key := bucket
-			if opts.normalizeBucket != nil {
-				key = opts.normalizeBucket(bucket)
-			}
+			key := normalize(bucket)
 			if _, ok := merged[key]; !ok {
 				merged[key] = make(map[reportID]struct{})
 			}
@@ -525,6 +577,29 @@ func (d data) partition(program programName, chartName graphName, buckets []buck
 			}
 		}
 	}
+	for wk := range opts.noisy {
+		// Each response is debiased on its own: responses of program
+		// reports of the same report are not merged.
+		for _, r := range opts.noisy[wk][pk][chartName] {
+			empty = false
+			if wk >= end {
+				end = wk
+			}
+			w := opts.weight(r.id)
+			responses += w
+			keys := make(map[bucketName]bool)
+			for _, bucket := range buckets {
+				key := normalize(bucket)
+				if _, ok := merged[key]; !ok {
+					merged[key] = make(map[reportID]struct{})
+				}
+				if r.buckets[bucket] && !keys[key] {
+					keys[key] = true
+					noisy[key] += w
+				}
+			}
+		}
+	}
 
 	if empty {
 		return nil
@@ -533,10 +608,14 @@ func (d data) partition(program programName, chartName graphName, buckets []buck
 	// datum.Week always points to the end date
 	for bucket, v := range merged {
 		if len(v) > 0 || !opts.ignoreEmptyBuckets {
+			value := opts.count(v)
+			if responses > 0 {
+				value += debias(noisy[bucket], responses, opts.epsilon)
+			}
 			d := &datum{
 				Week:  string(end),
 				Key:   string(bucket),
-				Value: float64(len(v)),
+				Value: value,
 			}
 			chart.Data = append(chart.Data, d)
 		}
@@ -553,6 +632,18 @@ func (d data) partition(program programName, chartName graphName, buckets []buck
 	return chart
 }
 
+// debias estimates the number of program reports that incremented a
+// bucket, given that n of their total responses report it as incremented,
+// using randomized response with the privacy parameter epsilon.
+//
+// Each report reports the truth with probability p, so that the expected
+// value of n is p*t + (1-p)*(total-t), for t the true number.
+func debias(n, total, epsilon float64) float64 {
+	p := tconfig.TruthProbability(epsilon)
+	t := (n - (1-p)*total) / (2*p - 1)
+	return math.Round(max(0, min(t, total)))
+}
+
 // weekName is the date of the report week in the format "YYYY-MM-DD".
 type weekName string
 
@@ -614,6 +705,9 @@ func group(reports []telemetry.Report) data {
 			result.writeCount(week, program, goversionCounter, bucketName(p.GoVersion), id, 1)
 			for c, value := range p.Counters {
 				chart, bucket := splitCounterName(c)
+				if slices.Contains(p.Noised, string(chart)) {
+					continue // in noisyData
+				}
 				result.writeCount(week, program, chart, bucket, id, value)
 			}
 		}
@@ -621,6 +715,55 @@ func group(reports []telemetry.Report) data {
 	return result
 }
 
+// noisyData holds the counters that are reported with noise, which are
+// not in data: the responses of each program report for each week,
+// program, and chart.
+type noisyData map[weekName]map[programName]map[graphName][]response
+
+// A response is the report of the buckets of a chart by a program report,
+// with randomized response.
+type response struct {
+	id      reportID
+	buckets map[bucketName]bool // whether each bucket is reported as incremented
+}
+
+// groupNoisy groups the counters that reports list as reported with noise
+// by week, program, and chart. Unlike other counters, the counters of
+// multiple program reports for the same program in a report are not
+// merged, as each is noised independently.
+func groupNoisy(reports []telemetry.Report) noisyData {
+	result := make(noisyData)
+	for _, r := range reports {
+		week := weekName(r.Week)
+		for _, p := range r.Programs {
+			if len(p.Noised) == 0 {
+				continue
+			}
+			program := programName(p.Program)
+			responses := make(map[graphName]response)
+			for _, chart := range p.Noised {
+				responses[graphName(chart)] = response{id: reportID(r.X), buckets: make(map[bucketName]bool)}
+			}
+			for c, value := range p.Counters {
+				chart, bucket := splitCounterName(c)
+				if r, ok := responses[chart]; ok {
+					r.buckets[bucket] = value > 0
+				}
+			}
+			if result[week] == nil {
+				result[week] = make(map[programName]map[graphName][]response)
+			}
+			if result[week][program] == nil {
+				result[week][program] = make(map[graphName][]response)
+			}
+			for chart, r := range responses {
+				result[week][program][chart] = append(result[week][program][chart], r)
+			}
+		}
+	}
+	return result
+}
+
 // writeCount writes the counter values to the result. When a report contains
 // multiple program reports for the same program, the value of the counters
 // in that report are summed together.
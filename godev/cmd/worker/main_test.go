@@ -5,6 +5,7 @@
 package main
 
 import (
+	"math"
 	"net/url"
 	"testing"
 	"time"
@@ -540,6 +541,31 @@ func TestPartition(t *testing.T) {
 			opts: partitionOptions{normalizeBucket: normalVersion},
 			want: nil,
 		},
+		{
+			name: "partial reports",
+			data: data{
+				"2999-01-01": {"example.com/mod/pkg": {"GOOS": {
+					"darwin": {0.1: 1, 0.2: 1},
+					"linux":  {0.3: 1},
+				},
+				}},
+			},
+			args: args{
+				program: "example.com/mod/pkg",
+				name:    "GOOS",
+				buckets: []bucketName{"darwin", "linux"},
+			},
+			opts: partitionOptions{weights: map[reportID]float64{0.2: 0.5, 0.3: 0.25}},
+			want: &chart{
+				ID:   "charts:example.com/mod/pkg:GOOS",
+				Name: "GOOS",
+				Type: "partition",
+				Data: []*datum{
+					{Week: "2999-01-01", Key: "darwin", Value: 1.5},
+					{Week: "2999-01-01", Key: "linux", Value: 0.25},
+				},
+			},
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -551,6 +577,51 @@ func TestPartition(t *testing.T) {
 	}
 }
 
+func TestPartition_Noisy(t *testing.T) {
+	// noised returns a program report with the given buckets of the
+	// editor chart reported with noise.
+	noised := func(a, b int64) *telemetry.ProgramReport {
+		return &telemetry.ProgramReport{
+			Program:  "example.com/mod/pkg",
+			Counters: map[string]int64{"editor:a": a, "editor:b": b},
+			Noised:   []string{"editor"},
+		}
+	}
+	reports := []telemetry.Report{
+		// Each program report is a response, even within a report.
+		{Week: "2999-01-01", X: 0.1, Programs: []*telemetry.ProgramReport{noised(1, 0), noised(0, 1)}},
+		{Week: "2999-01-01", X: 0.2, Programs: []*telemetry.ProgramReport{noised(1, 0)}},
+		{Week: "2999-01-01", X: 0.3, Programs: []*telemetry.ProgramReport{noised(1, 0)}},
+		{Week: "2999-01-01", X: 0.4, Programs: []*telemetry.ProgramReport{noised(1, 0)}},
+		{Week: "2999-01-01", X: 0.5, Programs: []*telemetry.ProgramReport{noised(1, 0)}},
+		{Week: "2999-01-01", X: 0.6, Programs: []*telemetry.ProgramReport{noised(0, 1)}},
+		{Week: "2999-01-01", X: 0.7, Programs: []*telemetry.ProgramReport{noised(0, 1)}},
+		// An uploader that predates noise reports exact counts.
+		{Week: "2999-01-01", X: 0.8, Programs: []*telemetry.ProgramReport{{
+			Program:  "example.com/mod/pkg",
+			Counters: map[string]int64{"editor:a": 3},
+		}}},
+	}
+	// Responses tell the truth with probability 3/4, so that of the 8
+	// responses, the 5 for a and 3 for b estimate 6 and 2 program reports.
+	got := group(reports).partition("example.com/mod/pkg", "editor", []bucketName{"a", "b"}, partitionOptions{
+		epsilon: math.Log(3),
+		noisy:   groupNoisy(reports),
+	})
+	want := &chart{
+		ID:   "charts:example.com/mod/pkg:editor",
+		Name: "editor",
+		Type: "partition",
+		Data: []*datum{
+			{Week: "2999-01-01", Key: "a", Value: 7},
+			{Week: "2999-01-01", Key: "b", Value: 2},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("partition() mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestCharts(t *testing.T) {
 	exampleData := group(exampleReports)
 	cfg := &config.Config{
@@ -681,7 +752,7 @@ func TestCharts(t *testing.T) {
 		},
 		NumReports: 1,
 	}
-	got := charts(cfg, "2999-01-01", "2999-01-01", exampleData, []float64{0.12345})
+	got := charts(cfg, "2999-01-01", "2999-01-01", exampleData, nil, nil, []float64{0.12345})
 	if diff := cmp.Diff(want, got); diff != "" {
 		t.Errorf("charts = %+v\n, (-want +got): %v", got, diff)
 	}
@@ -43,6 +43,9 @@
 //   - depth: (optional) stack counters only; the maximum stack depth to collect
 //   - error: (optional) the desired error rate for this chart, which
 //     determines collection rate
+//   - epsilon: (optional) partition charts only; the privacy parameter of
+//     the randomized response applied to each bucket of the chart (see
+//     TruthProbability in internal/config). Must be positive.
 //
 // Multiple records are separated by "---" lines.
 //
@@ -122,4 +125,5 @@ type ChartConfig struct {
 	Depth       int
 	Error       float64 // TODO(rfindley) is Error still useful?
 	Version     string
+	Epsilon     float64
 }
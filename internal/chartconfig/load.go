@@ -181,6 +181,7 @@ var fieldParsers = map[string]fieldParser{
 	"depth":       parseInt,
 	"error":       parseFloat,
 	"version":     parseString,
+	"epsilon":     parseFloat,
 }
 
 func parseString(v reflect.Value, input string) error {
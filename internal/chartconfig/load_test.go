@@ -38,6 +38,7 @@ issue: F2
 depth: 2
 error: 0.1
 version: v2.0.0
+epsilon: 1.5
 `,
 			[]chartconfig.ChartConfig{{
 				Title:       "A",
@@ -49,6 +50,7 @@ version: v2.0.0
 				Depth:       2,
 				Error:       0.1,
 				Version:     "v2.0.0",
+				Epsilon:     1.5,
 			}},
 		},
 		{
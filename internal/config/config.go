@@ -8,6 +8,7 @@ package config
 
 import (
 	"encoding/json"
+	"math"
 	"os"
 	"strings"
 
@@ -27,6 +28,7 @@ type Config struct {
 	pgcounterprefix map[pgkey]bool
 	pgstack         map[pgkey]bool
 	rate            map[pgkey]float64
+	epsilon         map[pgkey]float64
 }
 
 type pgkey struct {
@@ -56,6 +58,7 @@ func NewConfig(cfg *telemetry.UploadConfig) *Config {
 	ucfg.pgcounterprefix = make(map[pgkey]bool, len(ucfg.Programs))
 	ucfg.pgstack = make(map[pgkey]bool, len(ucfg.Programs))
 	ucfg.rate = make(map[pgkey]float64)
+	ucfg.epsilon = make(map[pgkey]float64)
 	for _, p := range ucfg.Programs {
 		ucfg.program[p.Name] = true
 		for _, v := range p.Versions {
@@ -65,6 +68,9 @@ func NewConfig(cfg *telemetry.UploadConfig) *Config {
 			for _, e := range Expand(c.Name) {
 				ucfg.pgcounter[pgkey{p.Name, e}] = true
 				ucfg.rate[pgkey{p.Name, e}] = c.Rate
+				if c.Epsilon > 0 {
+					ucfg.epsilon[pgkey{p.Name, e}] = c.Epsilon
+				}
 			}
 			prefix, _, found := strings.Cut(c.Name, ":")
 			if found {
@@ -115,6 +121,28 @@ func (r *Config) Rate(program, name string) float64 {
 	return r.rate[pgkey{program, name}]
 }
 
+// Epsilon returns the privacy parameter of the named counter of the
+// program, or 0 if the counter is reported without noise.
+func (r *Config) Epsilon(program, name string) float64 {
+	return r.epsilon[pgkey{program, name}]
+}
+
+// TruthProbability returns the probability with which randomized response
+// with the privacy parameter epsilon reports the truth.
+//
+// Uploaders report each bucket of a chart with an epsilon independently,
+// so that whether it was incremented is reported truthfully with
+// probability e^ε/(1+e^ε) only, and the server corrects the chart for the
+// noise. Each bucket is ε-differentially private, but the chart as a whole
+// is not: the privacy losses of its buckets add up. For a chart in which
+// exactly one bucket is incremented, changing that bucket changes two of
+// them, so the chart is only 2ε-differentially private; in general, it is
+// kε-differentially private if k buckets may differ. Smaller values of
+// epsilon are more private, and less accurate.
+func TruthProbability(epsilon float64) float64 {
+	return 1 / (1 + math.Exp(-epsilon)) // e^ε/(1+e^ε)
+}
+
 func set(slice []string) map[string]bool {
 	s := make(map[string]bool, len(slice))
 	for _, v := range slice {
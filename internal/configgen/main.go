@@ -177,9 +177,10 @@ func generate(gcfgs []chartconfig.ChartConfig, padding padding) (*telemetry.Uplo
 		}
 		minVersions[gcfg.Program] = minVersion(minVersions[gcfg.Program], gcfg.Version)
 		ccfg := telemetry.CounterConfig{
-			Name:  gcfg.Counter,
-			Rate:  1.0, // TODO(rfindley): how should rate be configured?
-			Depth: gcfg.Depth,
+			Name:    gcfg.Counter,
+			Rate:    1.0, // TODO(rfindley): how should rate be configured?
+			Depth:   gcfg.Depth,
+			Epsilon: gcfg.Epsilon,
 		}
 		if gcfg.Depth > 0 {
 			pcfg.Stacks = append(pcfg.Stacks, ccfg)
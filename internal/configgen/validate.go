@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"go/version"
+	"math"
 
 	"golang.org/x/mod/semver"
 	"golang.org/x/telemetry/internal/chartconfig"
@@ -44,6 +45,12 @@ func ValidateChartConfig(cfg chartconfig.ChartConfig) error {
 	if cfg.Depth != 0 && cfg.Type != "stack" {
 		reportf("depth can only be set for \"stack\" chart types")
 	}
+	if cfg.Epsilon < 0 || math.IsNaN(cfg.Epsilon) || math.IsInf(cfg.Epsilon, 0) {
+		reportf("invalid epsilon %v: must be positive", cfg.Epsilon)
+	}
+	if cfg.Epsilon != 0 && cfg.Type != "partition" {
+		reportf("epsilon can only be set for \"partition\" chart types")
+	}
 	valid := semver.IsValid
 	if telemetry.IsToolchainProgram(cfg.Program) {
 		valid = version.IsValid
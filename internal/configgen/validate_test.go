@@ -57,6 +57,10 @@ func TestValidate(t *testing.T) {
 
 		// valid of stack configuration
 		"depth:-1": {"non-negative", "stack"},
+
+		// validation of privacy configuration
+		"epsilon:-1":              {"positive", "partition"},
+		"type:stack\nepsilon:0.5": {"partition"},
 	}
 
 	for input, wantErrs := range tests {
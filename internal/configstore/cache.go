@@ -0,0 +1,202 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// Default cache policy; see [Cache].
+const (
+	DefaultCacheTTL    = 24 * time.Hour
+	DefaultCacheMaxAge = 7 * 24 * time.Hour
+)
+
+// A Cache holds the last known good version of the latest upload config in
+// a file, so that it is not downloaded on every upload, and remains
+// available when the download fails.
+type Cache struct {
+	File string // the cache file
+
+	// TTL is the time during which the cached config is used without
+	// checking for a newer version.
+	TTL time.Duration
+
+	// MaxAge is the time during which the cached config is used if there
+	// may be a newer version, but checking for it fails, such as when the
+	// machine is offline.
+	MaxAge time.Duration
+
+	// ReadOnly, if set, prevents updating the cache file.
+	ReadOnly bool
+}
+
+// A cacheEntry is the content of a cache file.
+type cacheEntry struct {
+	Checked time.Time // when the version was last found to be the latest
+	Version string
+	Env     []string // the environment overlay used to download the config
+	Config  *telemetry.UploadConfig
+}
+
+// Latest returns the latest upload config and its version, at time now, as
+// [DownloadContext] does for version "latest" and the given environment
+// overlay, but using the cache.
+//
+// Within the TTL, the cached config is returned. After that, the latest
+// version is queried, and the config is downloaded only if it is not the
+// cached version. If the query or the download fails, the cached config is
+// returned if it is within MaxAge; otherwise the error is. A cached config
+// that was checked after now is treated as expired.
+func (c Cache) Latest(ctx context.Context, now time.Time, envOverlay []string) (*telemetry.UploadConfig, string, error) {
+	entry := c.read(envOverlay)
+	if entry != nil && entry.checkedWithin(now, c.TTL) {
+		return entry.Config, entry.Version, nil
+	}
+	// fallback returns the cached config instead of err, if it is recent
+	// enough.
+	fallback := func(err error) (*telemetry.UploadConfig, string, error) {
+		if entry != nil && entry.checkedWithin(now, c.MaxAge) {
+			return entry.Config, entry.Version, nil
+		}
+		return nil, "", err
+	}
+
+	version, err := latestVersion(ctx, envOverlay)
+	if err != nil {
+		return fallback(err)
+	}
+	if entry != nil && entry.Version == version {
+		// The cached config is still the latest.
+		entry.Checked = now
+		c.write(entry)
+		return entry.Config, entry.Version, nil
+	}
+	cfg, version, err := DownloadContext(ctx, version, envOverlay)
+	if err != nil {
+		return fallback(err)
+	}
+	c.write(&cacheEntry{
+		Checked: now,
+		Version: version,
+		Env:     envOverlay,
+		Config:  cfg,
+	})
+	return cfg, version, nil
+}
+
+// Cached returns the cached upload config and its version, at time now,
+// without checking for a newer version, as [Cache.Latest] does when the
+// check fails: the cached config is returned only if it is within MaxAge.
+func (c Cache) Cached(now time.Time, envOverlay []string) (*telemetry.UploadConfig, string, error) {
+	entry := c.read(envOverlay)
+	if entry == nil {
+		return nil, "", fmt.Errorf("no cached upload config")
+	}
+	if !entry.checkedWithin(now, c.MaxAge) {
+		return nil, "", fmt.Errorf("cached upload config %s is out of date", entry.Version)
+	}
+	return entry.Config, entry.Version, nil
+}
+
+// checkedWithin reports whether the entry was checked within d before now.
+// An entry checked after now, such as when the clock has been set back,
+// is not trusted.
+func (e *cacheEntry) checkedWithin(now time.Time, d time.Duration) bool {
+	age := now.Sub(e.Checked)
+	return age >= 0 && age < d
+}
+
+// read returns the cache entry for the environment overlay, or nil if
+// there is none.
+func (c Cache) read(envOverlay []string) *cacheEntry {
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(data, entry); err != nil || entry.Config == nil || !equal(entry.Env, envOverlay) {
+		return nil
+	}
+	return entry
+}
+
+func equal(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// write writes the cache entry. Failures are ignored: the config is
+// downloaded again by the next call.
+func (c Cache) write(entry *cacheEntry) {
+	if c.ReadOnly {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.File), 0777); err != nil {
+		return
+	}
+	// Write and rename, so that readers never see a partial entry, and
+	// concurrent writers do not share a temporary file.
+	tmp, err := os.CreateTemp(filepath.Dir(c.File), filepath.Base(c.File)+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if werr == nil {
+		werr = cerr
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), c.File)
+	}
+	if werr != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// latestVersion returns the latest version of the config module, using
+// "go list", which only queries the module proxy for the version, without
+// downloading the module.
+func latestVersion(ctx context.Context, envOverlay []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "list", "-m", "-json", ModulePath+"@latest")
+	needNoConsole(cmd)
+	cmd.Env = append(os.Environ(), envOverlay...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("failed to query the config version: %w", ctx.Err())
+		}
+		return "", fmt.Errorf("failed to query the config version: %w\n%s", err, &stderr)
+	}
+	var info struct {
+		Version string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil || info.Version == "" {
+		return "", fmt.Errorf("failed to query the config version (invalid JSON): %v", err)
+	}
+	return info.Version, nil
+}
@@ -11,6 +11,7 @@ package configstore
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -44,6 +45,13 @@ func Downloads() int64 {
 //
 // The second result is the canonical version of the requested configuration.
 func Download(version string, envOverlay []string) (*telemetry.UploadConfig, string, error) {
+	return DownloadContext(context.Background(), version, envOverlay)
+}
+
+// DownloadContext is like [Download], but the go command is killed if ctx is
+// done before it completes. In that case, the resulting error wraps the
+// context's error.
+func DownloadContext(ctx context.Context, version string, envOverlay []string) (*telemetry.UploadConfig, string, error) {
 	atomic.AddInt64(&downloads, 1)
 
 	if version == "" {
@@ -51,12 +59,15 @@ func Download(version string, envOverlay []string) (*telemetry.UploadConfig, str
 	}
 	modVer := ModulePath + "@" + version
 	var stdout, stderr bytes.Buffer
-	cmd := exec.Command("go", "mod", "download", "-json", modVer)
+	cmd := exec.CommandContext(ctx, "go", "mod", "download", "-json", modVer)
 	needNoConsole(cmd)
 	cmd.Env = append(os.Environ(), envOverlay...)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, "", fmt.Errorf("failed to download config module: %w", ctx.Err())
+		}
 		var info struct {
 			Error string
 		}
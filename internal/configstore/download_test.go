@@ -5,11 +5,17 @@
 package configstore_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/telemetry/internal/configstore"
 	"golang.org/x/telemetry/internal/configtest"
@@ -66,6 +72,15 @@ func TestDownload(t *testing.T) {
 			t.Errorf("unexpected error message: %v", err)
 		}
 	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		got, ver, err := configstore.DownloadContext(ctx, configVersion, env)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("DownloadContext(canceled) = %v %+v, %v; want context.Canceled", ver, got, err)
+		}
+	})
 }
 
 func stringify(x any) string {
@@ -75,3 +90,81 @@ func stringify(x any) string {
 	}
 	return string(ret)
 }
+
+func TestCache(t *testing.T) {
+	testenv.NeedsGo(t)
+
+	configVersion := "v0.1.0"
+	in := &telemetry.UploadConfig{
+		GOOS:      []string{"linux"},
+		GOARCH:    []string{"amd64"},
+		GoVersion: []string{"go1.22.0"},
+	}
+	env := configtest.LocalProxyEnv(t, in, configVersion)
+
+	cache := configstore.Cache{
+		File:   filepath.Join(t.TempDir(), "configcache.json"),
+		TTL:    24 * time.Hour,
+		MaxAge: 7 * 24 * time.Hour,
+	}
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	offline, cancel := context.WithCancel(context.Background())
+	cancel() // the version check fails, as it does offline
+
+	tests := []struct {
+		desc          string
+		ctx           context.Context
+		now           time.Time
+		wantErr       bool
+		wantDownloads int64 // downloads by this call; if none, a config is from the cache
+	}{
+		{"initial", context.Background(), start, false, 1},
+		{"within TTL", context.Background(), start.Add(time.Hour), false, 0},
+		{"same version", context.Background(), start.Add(25 * time.Hour), false, 0},
+		{"offline, clock set back", offline, start, true, 0},
+		{"clock set back", context.Background(), start, false, 0},
+		{"offline within max age", offline, start.Add(3 * 24 * time.Hour), false, 0},
+		{"offline after max age", offline, start.Add(9 * 24 * time.Hour), true, 0},
+	}
+	for _, test := range tests {
+		downloads := configstore.Downloads()
+		got, version, err := cache.Latest(test.ctx, test.now, env)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Fatalf("%s: Latest() failed: %v (want error: %t)", test.desc, err, test.wantErr)
+		}
+		if err == nil && (version != configVersion || !reflect.DeepEqual(*got, *in)) {
+			t.Errorf("%s: Latest() = %v, %v; want %v, %v", test.desc, stringify(got), version, stringify(in), configVersion)
+		}
+		if d := configstore.Downloads() - downloads; d != test.wantDownloads {
+			t.Errorf("%s: got %d downloads, want %d", test.desc, d, test.wantDownloads)
+		}
+	}
+
+	// The cache is specific to the environment overlay.
+	downloads := configstore.Downloads()
+	otherEnv := append(env[:len(env):len(env)], "GOFLAGS=-mod=mod")
+	if _, _, err := cache.Latest(context.Background(), start.Add(25*time.Hour), otherEnv); err != nil {
+		t.Fatalf("Latest(other env) failed: %v", err)
+	}
+	if d := configstore.Downloads() - downloads; d != 1 {
+		t.Errorf("Latest(other env): got %d downloads, want 1", d)
+	}
+}
+
+func TestCache_ReadOnly(t *testing.T) {
+	testenv.NeedsGo(t)
+
+	env := configtest.LocalProxyEnv(t, &telemetry.UploadConfig{}, "v0.1.0")
+	cache := configstore.Cache{
+		File:     filepath.Join(t.TempDir(), "configcache.json"),
+		TTL:      24 * time.Hour,
+		MaxAge:   7 * 24 * time.Hour,
+		ReadOnly: true,
+	}
+	if _, _, err := cache.Latest(context.Background(), time.Now(), env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache.File); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read-only cache wrote %s (stat error: %v)", cache.File, err)
+	}
+}
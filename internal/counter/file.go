@@ -8,17 +8,20 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"golang.org/x/telemetry/internal/filelock"
 	"golang.org/x/telemetry/internal/mmap"
 	"golang.org/x/telemetry/internal/telemetry"
 )
@@ -126,6 +129,10 @@ func (f *file) lookup(name string) counterPtr {
 // ErrDisabled is the error returned when telemetry is disabled.
 var ErrDisabled = errors.New("counter: disabled as Go telemetry is off")
 
+// ErrInUse is the error returned by [Expire] when the counter file is in
+// use by a process.
+var ErrInUse = errors.New("counter: file is in use")
+
 var (
 	errNoBuildInfo = errors.New("counter: missing build info")
 	errCorrupt     = errors.New("counter: corrupt counter file")
@@ -369,6 +376,17 @@ var (
 	rotating bool
 )
 
+// SetBuildInfo overrides the build information used to name and describe the
+// counter file, which is otherwise read from the running executable.
+//
+// It is used by a dedicated telemetry sidecar to record counters on behalf of
+// its parent program, and must be called before [Open].
+func SetBuildInfo(bi *debug.BuildInfo) {
+	defaultFile.mu.Lock()
+	defer defaultFile.mu.Unlock()
+	defaultFile.buildInfo = bi
+}
+
 // Open associates counting with the defaultFile.
 // The returned function is for testing only, and should
 // be called after all Inc()s are finished, but before
@@ -387,6 +405,13 @@ func Open(rotate bool) func() {
 			// No need to clean up.
 			return
 		}
+		if err := telemetry.Default.Migrate(); err != nil {
+			// The directory was written by a newer version of this library, or
+			// could not be upgraded: don't write anything into it.
+			debugPrintf("Open: %v", err)
+			defaultFile.err = err
+			return
+		}
 		debugPrintf("Open(%v)", rotate)
 		if rotate {
 			defaultFile.rotate() // calls rotate1 and schedules a rotation
@@ -465,7 +490,7 @@ func openMapped(name, meta string) (_ *mappedFile, err error) {
 		return nil, err
 	}
 
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	f, err := openShared(name)
 	if err != nil {
 		return nil, err
 	}
@@ -534,6 +559,117 @@ func mappedHeader(meta string) ([]byte, error) {
 	return hdr, nil
 }
 
+// openShared opens the file with the given name, creating it if needed,
+// and places a shared lock on it, which is held until the file is closed,
+// so that [Expire] leaves it alone. If Expire renamed the file while it was
+// being locked, openShared opens a new file with the given name instead.
+//
+// Where file locking is not supported, the file is not locked. Where locks
+// are record locks, held by the process (see package filelock), closing a
+// previous mapping of the file releases the lock.
+func openShared(name string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return nil, err
+		}
+		if err := filelock.RLock(f); err != nil {
+			if err == filelock.ErrNotSupported {
+				return f, nil
+			}
+			f.Close()
+			return nil, err
+		}
+		same, err := isFile(f, name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if same {
+			return f, nil
+		}
+		f.Close() // renamed by Expire
+	}
+}
+
+// isFile reports whether the open file f is the one with the given name.
+func isFile(f *os.File, name string) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	named, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi, named), nil
+}
+
+// Expire makes the counter file with the given name expire early, at end,
+// truncated to the second, by rewriting its TimeEnd in place. It then
+// renames the file, so that processes that start later, whose metadata
+// would not match that of the file, create a new file instead, and it
+// returns the new name.
+//
+// Expire fails with [ErrInUse] if a process has the file open, since the
+// process would keep incrementing the counters of the file after it
+// expired, and these increments would be lost. Expire also fails where file
+// locking is not supported.
+func Expire(name string, end time.Time) (string, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if ok, err := filelock.TryLock(f); !ok {
+		if err == nil {
+			err = ErrInUse
+		}
+		return "", err
+	}
+	if same, err := isFile(f, name); !same {
+		if err == nil {
+			err = fmt.Errorf("%s was renamed", name)
+		}
+		return "", err
+	}
+	hdr := make([]byte, round(len(hdrPrefix), 4)+4+maxMetaLen)
+	n, err := f.ReadAt(hdr, 0)
+	if n < len(hdrPrefix)+4 || !bytes.HasPrefix(hdr, []byte(hdrPrefix)) {
+		if err == nil || err == io.EOF {
+			err = errCorrupt
+		}
+		return "", err
+	}
+	hdr = hdr[:n]
+	const key = "\nTimeEnd: "
+	i := bytes.Index(hdr, []byte(key))
+	if i < 0 {
+		return "", fmt.Errorf("%s: missing TimeEnd", name)
+	}
+	off := i + len(key)
+	j := bytes.IndexByte(hdr[off:], '\n')
+	if j < 0 {
+		return "", errCorrupt
+	}
+	value := end.UTC().Format(time.RFC3339)
+	if len(value) != j {
+		return "", fmt.Errorf("%s: cannot replace TimeEnd %s with %s", name, hdr[off:off+j], value)
+	}
+	if _, err := f.WriteAt([]byte(value), int64(off)); err != nil {
+		return "", err
+	}
+	suffix := "." + FileVersion + ".count"
+	newName := strings.TrimSuffix(name, suffix) + ".expired-" + end.UTC().Format("20060102T150405Z") + suffix
+	if err := os.Rename(name, newName); err != nil {
+		return "", err
+	}
+	return newName, f.Close()
+}
+
 func (m *mappedFile) place(limit uint32, name string) (start, end uint32) {
 	if limit == 0 {
 		// first record in file
@@ -219,3 +219,56 @@ func (b counterStateBits) String() string {
 	extra := uint64(b&stateExtra) >> stateExtraShift
 	return fmt.Sprintf("rdrs:0x%x locked:%v\thavePtr:%v\textra:%d", rdrs, locked, havePtr, extra)
 }
+
+func TestExpire(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	setup(t)
+
+	var f file
+	c := f.New("gophers")
+	c.Inc()
+	f.rotate()
+	name := f.current.Load().f.Name()
+
+	end := getnow().Add(90 * time.Minute)
+	switch runtime.GOOS {
+	case "aix", "illumos", "solaris":
+		// Record locks do not exclude files opened by the same process.
+	default:
+		if _, err := Expire(name, end); err != ErrInUse {
+			t.Fatalf("Expire of a file in use = %v, want %v", err, ErrInUse)
+		}
+	}
+	close(&f)
+
+	newName, err := Expire(name, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expired file still exists under its name: %v", err)
+	}
+	data, err := os.ReadFile(newName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(newName, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := parsed.Meta["TimeEnd"], end.Format(time.RFC3339); got != want {
+		t.Errorf("TimeEnd = %s, want %s", got, want)
+	}
+	if got := parsed.Count["gophers"]; got != 1 {
+		t.Errorf("gophers = %d, want 1", got)
+	}
+
+	// Processes that start later use a new file.
+	var g file
+	defer close(&g)
+	g.New("gophers").Inc()
+	g.rotate()
+	if got := g.current.Load(); got == nil || got.f.Name() != name {
+		t.Fatalf("no new file was opened at %s after Expire", name)
+	}
+}
@@ -75,11 +75,41 @@ func (c *StackCounter) Inc() {
 // use for the given stack of program counters.
 // The name encodes the stack.
 func EncodeStack(pcs []uintptr, prefix string) string {
-	var locs []string
-	lastImport := ""
+	return EncodeFrames(StackFrames(pcs), prefix)
+}
+
+// StackFrames symbolizes the given stack of program counters, as needed
+// to encode a stack counter. Inlined calls are expanded.
+func StackFrames(pcs []uintptr) []Frame {
+	var frames []Frame
 	frs := runtime.CallersFrames(pcs)
 	for {
 		fr, more := frs.Next()
+		frame := Frame{Function: fr.Function, Line: fr.Line}
+		if fr.Func != nil {
+			_, frame.EntryLine = fr.Func.FileLine(fr.Entry)
+		}
+		frames = append(frames, frame)
+		if !more {
+			break
+		}
+	}
+	return frames
+}
+
+// A Frame is a symbolized stack frame, as needed to encode a stack counter.
+type Frame struct {
+	Function  string // fully qualified function name
+	Line      int    // line number of the PC
+	EntryLine int    // line number of the function's entry, or 0 if unknown
+}
+
+// EncodeFrames is like [EncodeStack], but encodes frames that have already
+// been symbolized, for example from the symbol table of another executable.
+func EncodeFrames(frames []Frame, prefix string) string {
+	var locs []string
+	lastImport := ""
+	for _, fr := range frames {
 		// TODO(adonovan): this CutLast(".") operation isn't
 		// appropriate for generic function symbols.
 		path, fname := cutLastDot(fr.Function)
@@ -89,24 +119,25 @@ func EncodeStack(pcs []uintptr, prefix string) string {
 			lastImport = path
 		}
 		var loc string
-		if fr.Func != nil {
+		if fr.EntryLine != 0 {
 			// Use function-relative line numbering.
 			// f:+2 means two lines into function f.
 			// f:-1 should never happen, but be conservative.
-			_, entryLine := fr.Func.FileLine(fr.Entry)
-			loc = fmt.Sprintf("%s.%s:%+d", path, fname, fr.Line-entryLine)
+			loc = fmt.Sprintf("%s.%s:%+d", path, fname, fr.Line-fr.EntryLine)
 		} else {
 			// The function is non-Go code or is fully inlined:
 			// use absolute line number within enclosing file.
 			loc = fmt.Sprintf("%s.%s:=%d", path, fname, fr.Line)
 		}
 		locs = append(locs, loc)
-		if !more {
-			break
-		}
 	}
 
-	name := prefix + "\n" + strings.Join(locs, "\n")
+	return TruncateName(prefix + "\n" + strings.Join(locs, "\n"))
+}
+
+// TruncateName truncates a stack counter name that is too long to be
+// recorded, marking it as truncated.
+func TruncateName(name string) string {
 	if len(name) > maxNameLen {
 		const bad = "\ntruncated\n"
 		name = name[:maxNameLen-len(bad)] + bad
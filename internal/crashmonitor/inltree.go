@@ -0,0 +1,281 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file decodes the inlining tables of an executable, which
+// [debug/gosym] does not expose, so that the symbolizer can expand
+// inlined calls as [runtime.CallersFrames] does.
+//
+// The layout of the tables is that of the runtime's pcHeader, _func and
+// inlinedCall types, for the pc/line tables of Go 1.20 and later.
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	go120PCLnTabMagic = 0xfffffff1
+
+	pcdataInlTreeIndex = 2 // abi.PCDATA_InlTreeIndex
+	funcdataInlTree    = 3 // abi.FUNCDATA_InlTree
+
+	funcHeaderSize  = 44 // size of the fixed part of the runtime's _func
+	inlinedCallSize = 16 // size of the runtime's inlinedCall
+)
+
+// inlTables holds the tables of an executable that describe inlining.
+type inlTables struct {
+	order     binary.ByteOrder
+	quantum   uint64 // instruction size quantum of pc deltas
+	textStart uint64 // link-time address of the text segment
+	funcnames []byte // function name table
+	pctab     []byte // pc-value tables
+	functab   []byte // function table, followed by the _func structures
+	nfunc     int
+	gofunc    []byte // funcdata, starting at the go:func.* symbol
+}
+
+// An inlinedCall is a call inlined at a PC.
+type inlinedCall struct {
+	function string // name of the called function
+	parentPC uint64 // a PC of the call site
+}
+
+// readInlTables reads the inlining tables of f, whose pc/line table and
+// text segment address are given.
+func readInlTables(f *elf.File, pclntab []byte, textStart uint64) (*inlTables, error) {
+	order := f.ByteOrder
+	if len(pclntab) < 8 || order.Uint32(pclntab) != go120PCLnTabMagic {
+		return nil, fmt.Errorf("unsupported pc/line table version")
+	}
+	quantum, ptrSize := uint64(pclntab[6]), int(pclntab[7])
+	if ptrSize != 4 && ptrSize != 8 || len(pclntab) < 8+8*ptrSize {
+		return nil, fmt.Errorf("invalid pc/line table header")
+	}
+	word := func(i int) uint64 { // i'th word of the header after the magic
+		p := pclntab[8+i*ptrSize:]
+		if ptrSize == 4 {
+			return uint64(order.Uint32(p))
+		}
+		return order.Uint64(p)
+	}
+	nfunc := word(0)
+	section := func(off uint64) ([]byte, error) {
+		if off > uint64(len(pclntab)) {
+			return nil, fmt.Errorf("invalid pc/line table header")
+		}
+		return pclntab[off:], nil
+	}
+	funcnames, err := section(word(3))
+	if err != nil {
+		return nil, err
+	}
+	pctab, err := section(word(6))
+	if err != nil {
+		return nil, err
+	}
+	functab, err := section(word(7))
+	if err != nil {
+		return nil, err
+	}
+	if nfunc > uint64(len(functab))/8 {
+		return nil, fmt.Errorf("invalid pc/line table header")
+	}
+
+	gofunc, err := goFuncData(f, word(3), ptrSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inlTables{
+		order:     order,
+		quantum:   quantum,
+		textStart: textStart,
+		funcnames: funcnames,
+		pctab:     pctab,
+		functab:   functab,
+		nfunc:     int(nfunc),
+		gofunc:    gofunc,
+	}, nil
+}
+
+// goFuncData returns the contents of f from the go:func.* symbol, to which
+// funcdata offsets are relative. The pc/line table's header gives the
+// offset of its function name table and the pointer size.
+func goFuncData(f *elf.File, funcnameOff uint64, ptrSize int) ([]byte, error) {
+	addr, err := goFuncAddr(f, funcnameOff, ptrSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, sect := range f.Sections {
+		if sect.Type != elf.SHT_NOBITS && sect.Addr <= addr && addr < sect.Addr+sect.Size {
+			data, err := sect.Data()
+			if err != nil {
+				return nil, err
+			}
+			return data[addr-sect.Addr:], nil
+		}
+	}
+	return nil, fmt.Errorf("go:func.* is outside the executable's sections")
+}
+
+// goFuncAddr returns the address of the go:func.* symbol of f.
+//
+// If f has no symbol table, as test executables and those linked with -s
+// do not, the address is read from the runtime's moduledata, which starts
+// with the address of the pc/line table and its function name table.
+func goFuncAddr(f *elf.File, funcnameOff uint64, ptrSize int) (uint64, error) {
+	if syms, err := f.Symbols(); err == nil {
+		for _, sym := range syms {
+			if sym.Name == "go:func.*" {
+				return sym.Value, nil
+			}
+		}
+	}
+	pclntab := f.Section(".gopclntab")
+	if pclntab == nil {
+		return 0, fmt.Errorf("no go:func.* symbol or .gopclntab section")
+	}
+	word := func(data []byte, i int) uint64 {
+		if ptrSize == 4 {
+			return uint64(f.ByteOrder.Uint32(data[i*4:]))
+		}
+		return f.ByteOrder.Uint64(data[i*8:])
+	}
+	// Indices of fields of the runtime's moduledata, in words.
+	const (
+		gofuncIndex    = 43 // gofunc, since epclntab follows it
+		epclntabIndex  = 44
+		oldGofuncIndex = 40 // gofunc, in earlier layouts without epclntab
+	)
+	for _, name := range []string{".go.module", ".noptrdata"} {
+		sect := f.Section(name)
+		if sect == nil || sect.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := sect.Data()
+		if err != nil {
+			return 0, err
+		}
+		for ; len(data) >= (epclntabIndex+1)*ptrSize; data = data[ptrSize:] {
+			if word(data, 0) != pclntab.Addr || word(data, 1) != pclntab.Addr+funcnameOff {
+				continue
+			}
+			if word(data, epclntabIndex) == pclntab.Addr+pclntab.Size {
+				return word(data, gofuncIndex), nil
+			}
+			return word(data, oldGofuncIndex), nil
+		}
+	}
+	return 0, fmt.Errorf("no go:func.* symbol or moduledata")
+}
+
+// inlinedCalls returns the calls inlined at the given link-time PC, from
+// the innermost one outwards, or nil if there are none.
+func (t *inlTables) inlinedCalls(pc uint64) []inlinedCall {
+	fn, entry := t.funcAt(pc)
+	if fn == nil {
+		return nil
+	}
+	tree := t.funcdata(fn, funcdataInlTree)
+	if tree == nil {
+		return nil
+	}
+	var calls []inlinedCall
+	for ix := t.pcdata(fn, entry, pcdataInlTreeIndex, pc); ix >= 0; ix = t.pcdata(fn, entry, pcdataInlTreeIndex, pc) {
+		off := int(ix) * inlinedCallSize
+		if off+inlinedCallSize > len(tree) || len(calls) > 100 {
+			return nil // corrupt table
+		}
+		call := tree[off : off+inlinedCallSize]
+		name := t.funcName(int32(t.order.Uint32(call[4:])))
+		pc = entry + uint64(int32(t.order.Uint32(call[8:])))
+		calls = append(calls, inlinedCall{function: name, parentPC: pc})
+	}
+	return calls
+}
+
+// funcAt returns the _func structure of the function containing pc, and
+// its entry PC, or nil if there is none.
+func (t *inlTables) funcAt(pc uint64) ([]byte, uint64) {
+	if pc < t.textStart {
+		return nil, 0
+	}
+	off := pc - t.textStart
+	entryOff := func(i int) uint64 { return uint64(t.order.Uint32(t.functab[8*i:])) }
+	i := sort.Search(t.nfunc, func(i int) bool { return entryOff(i) > off }) - 1
+	if i < 0 {
+		return nil, 0
+	}
+	funcOff := uint64(t.order.Uint32(t.functab[8*i+4:]))
+	if funcOff+funcHeaderSize > uint64(len(t.functab)) {
+		return nil, 0
+	}
+	return t.functab[funcOff:], t.textStart + entryOff(i)
+}
+
+// pcdata returns the value at pc of the given pc-value table of the
+// function fn with the given entry PC, or -1 if there is none.
+func (t *inlTables) pcdata(fn []byte, entry uint64, table int, pc uint64) int32 {
+	npcdata := int(t.order.Uint32(fn[28:]))
+	if table >= npcdata || funcHeaderSize+4*(table+1) > len(fn) {
+		return -1
+	}
+	off := t.order.Uint32(fn[funcHeaderSize+4*table:])
+	if off == 0 || uint64(off) >= uint64(len(t.pctab)) {
+		return -1
+	}
+	p := t.pctab[off:]
+	val, tabPC := int32(-1), entry
+	for first := true; ; first = false {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || uvdelta == 0 && !first {
+			return -1
+		}
+		p = p[n:]
+		val += int32(-(uvdelta & 1) ^ (uvdelta >> 1))
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return -1
+		}
+		p = p[n:]
+		tabPC += pcdelta * t.quantum
+		if pc < tabPC {
+			return val
+		}
+	}
+}
+
+// funcdata returns the given funcdata of the function fn, or nil if there
+// is none.
+func (t *inlTables) funcdata(fn []byte, i int) []byte {
+	npcdata, nfuncdata := int(t.order.Uint32(fn[28:])), int(fn[43])
+	p := funcHeaderSize + 4*npcdata + 4*i
+	if i >= nfuncdata || p+4 > len(fn) {
+		return nil
+	}
+	off := t.order.Uint32(fn[p:])
+	if off == ^uint32(0) || uint64(off) >= uint64(len(t.gofunc)) {
+		return nil
+	}
+	return t.gofunc[off:]
+}
+
+// funcName returns the function name at the given offset of the function
+// name table.
+func (t *inlTables) funcName(off int32) string {
+	if off < 0 || int(off) >= len(t.funcnames) {
+		return "?"
+	}
+	name := t.funcnames[off:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name)
+}
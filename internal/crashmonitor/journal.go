@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file defines the crash journal, a local record of recent crash
+// reports that allows users to see the traceback behind a crash counter.
+// Journal entries are never uploaded.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// A JournalEntry is a crash report saved in the crash journal.
+//
+// There is one entry per crash counter: a later crash with the same
+// counter name replaces the report of an earlier one.
+type JournalEntry struct {
+	ID      string    // identifies the entry; derived from Counter
+	Counter string    // name of the counter incremented for the crash
+	Kind    string    // kind of the crash, as in the crash/kind counter
+	Time    time.Time // time at which the crash was last recorded
+	Count   int       // number of crashes recorded in this entry
+	Report  string    // redacted crash report of the latest crash
+}
+
+// maxJournalReport is the maximum size of the report in a journal entry.
+const maxJournalReport = 64 << 10
+
+// maxJournalEntries is the maximum number of entries in the journal. When
+// it is exceeded, the least recent entries are removed.
+// (Mutable for testing.)
+var maxJournalEntries = 32
+
+// journalID returns the ID of the journal entry for the given counter.
+func journalID(counterName string) string {
+	sum := sha256.Sum256([]byte(counterName))
+	return hex.EncodeToString(sum[:8])
+}
+
+// saveCrash records the given crash report in the journal of dir.
+func saveCrash(dir telemetry.Dir, counterName, kind string, crash []byte) error {
+	crashDir := dir.CrashDir()
+	if err := os.MkdirAll(crashDir, 0777); err != nil {
+		return err
+	}
+	entry := &JournalEntry{ID: journalID(counterName)}
+	fname := filepath.Join(crashDir, entry.ID+".json")
+	// Concurrent crashes may race to update the same entry, in which case
+	// one occurrence may go uncounted. The counter itself remains accurate.
+	if data, err := os.ReadFile(fname); err == nil {
+		_ = json.Unmarshal(data, entry)
+	}
+	entry.Counter = counterName
+	entry.Kind = kind
+	entry.Time = time.Now().UTC()
+	entry.Count++
+	entry.Report = redact(crash)
+
+	data, err := json.MarshalIndent(entry, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(crashDir, entry.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(data)
+	if err := tmp.Close(); werr == nil {
+		werr = err
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), fname)
+	}
+	if werr != nil {
+		os.Remove(tmp.Name())
+		return werr
+	}
+	return pruneJournal(dir)
+}
+
+// pruneJournal removes the least recent entries of the journal of dir,
+// beyond maxJournalEntries, and loop state files that have expired.
+func pruneJournal(dir telemetry.Dir) error {
+	entries, err := ReadJournal(dir)
+	if err != nil {
+		return err
+	}
+	for len(entries) > maxJournalEntries {
+		last := entries[len(entries)-1]
+		if err := RemoveJournalEntry(dir, last.ID); err != nil {
+			return err
+		}
+		entries = entries[:len(entries)-1]
+	}
+	fis, err := os.ReadDir(dir.CrashDir())
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".state") {
+			continue
+		}
+		if info, err := fi.Info(); err == nil && time.Since(info.ModTime()) > loopPeriod {
+			os.Remove(filepath.Join(dir.CrashDir(), fi.Name()))
+		}
+	}
+	return nil
+}
+
+// ReadJournal returns the entries of the crash journal of dir, most recent
+// first.
+func ReadJournal(dir telemetry.Dir) ([]*JournalEntry, error) {
+	fis, err := os.ReadDir(dir.CrashDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*JournalEntry
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir.CrashDir(), fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entry := new(JournalEntry)
+		if err := json.Unmarshal(data, entry); err != nil {
+			return nil, fmt.Errorf("%s: %v", fi.Name(), err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// RemoveJournalEntry removes the crash journal entry of dir with the given ID.
+func RemoveJournalEntry(dir telemetry.Dir, id string) error {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return fmt.Errorf("invalid crash ID %q", id)
+	}
+	return os.Remove(filepath.Join(dir.CrashDir(), id+".json"))
+}
+
+// redact returns a copy of the crash report that is suitable for saving:
+// the sentinel line and the argument values of each stack frame are
+// removed, the values of environment variables are replaced by their
+// names, and the result is truncated to maxJournalReport bytes.
+func redact(crash []byte) string {
+	lines := strings.Split(string(crash), "\n")
+	var out []string
+	for i, line := range lines {
+		if strings.HasPrefix(line, "sentinel ") {
+			continue
+		}
+		// A frame is a pair of lines:
+		//   SYMBOL(ARGS)
+		//   \tFILE:LINE ...
+		if !strings.HasPrefix(line, "\t") &&
+			i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+			line = redactArgs(line)
+		}
+		out = append(out, line)
+	}
+	report := strings.Join(out, "\n")
+
+	// Replace the values of environment variables, longest first. Short
+	// values are left alone, as they are likely to match unrelated text.
+	type envVar struct{ name, value string }
+	var env []envVar
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && len(value) >= 6 && !strings.HasPrefix(name, "GO_TELEMETRY_") {
+			env = append(env, envVar{name, value})
+		}
+	}
+	sort.Slice(env, func(i, j int) bool { return len(env[i].value) > len(env[j].value) })
+	for _, v := range env {
+		report = strings.ReplaceAll(report, v.value, "$"+v.name)
+	}
+
+	if len(report) > maxJournalReport {
+		const truncated = "\n...truncated...\n"
+		report = report[:maxJournalReport-len(truncated)] + truncated
+	}
+	return report
+}
+
+// redactArgs replaces the arguments of the symbol(args) line of a stack
+// frame by "(...)". The symbol itself may contain parens, as in
+// pkg.(*T).method(args).
+func redactArgs(line string) string {
+	if !strings.HasSuffix(line, ")") {
+		return line
+	}
+	depth := 0
+	for i := len(line) - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				if i+2 == len(line) {
+					return line // no arguments
+				}
+				return line[:i] + "(...)"
+			}
+		}
+	}
+	return line
+}
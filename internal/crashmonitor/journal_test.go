@@ -0,0 +1,141 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/telemetry/internal/crashmonitor"
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+func TestJournal(t *testing.T) {
+	t.Setenv("CRASHMONITOR_TEST_SECRET", "hunter2-secret")
+	const crash = "sentinel 45ad60\n" +
+		"panic: bad token hunter2-secret\n" +
+		"\n" +
+		"goroutine 1 gp=0xc000002380 m=0 mp=0x55e320 [running]:\n" +
+		"panic({0x4a1b20?, 0x4e8c10?})\n" +
+		"\t/usr/lib/go/src/runtime/panic.go:785 +0x132 fp=0xc000058f28 sp=0xc000058e78 pc=0x45ad7d\n" +
+		"main.(*T).check(0xc000012345, {0xc0000160a8, 0x5})\n" +
+		"\t/tmp/x.go:12 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45ad9d\n" +
+		"main.main()\n" +
+		"\t/tmp/x.go:5 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45adbd\n" +
+		"created by main.init in goroutine 1\n"
+	const counterName = "crash/crash\nruntime.gopanic:+69\nmain.(*T).check:+3\n\".main:+1"
+
+	dir := telemetry.NewDir(t.TempDir())
+	for i := 0; i < 2; i++ {
+		if err := crashmonitor.SaveCrash(dir, counterName, "panic", []byte(crash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := crashmonitor.ReadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d journal entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Counter != counterName || entry.Kind != "panic" || entry.Count != 2 {
+		t.Errorf("got entry {Counter: %q, Kind: %q, Count: %d}, want {%q, panic, 2}", entry.Counter, entry.Kind, entry.Count, counterName)
+	}
+	want := "panic: bad token $CRASHMONITOR_TEST_SECRET\n" +
+		"\n" +
+		"goroutine 1 gp=0xc000002380 m=0 mp=0x55e320 [running]:\n" +
+		"panic(...)\n" +
+		"\t/usr/lib/go/src/runtime/panic.go:785 +0x132 fp=0xc000058f28 sp=0xc000058e78 pc=0x45ad7d\n" +
+		"main.(*T).check(...)\n" +
+		"\t/tmp/x.go:12 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45ad9d\n" +
+		"main.main()\n" +
+		"\t/tmp/x.go:5 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45adbd\n" +
+		"created by main.init in goroutine 1\n"
+	if entry.Report != want {
+		t.Errorf("got report <<%s>>, want <<%s>>", entry.Report, want)
+	}
+
+	// Reports are size-capped.
+	big := strings.Repeat("x", 1<<20)
+	if err := crashmonitor.SaveCrash(dir, "crash/crash\nbig", "other", []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = crashmonitor.ReadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d journal entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Kind == "other" && (len(entry.Report) >= len(big) || !strings.HasSuffix(entry.Report, "truncated...\n")) {
+			t.Errorf("large report was not truncated")
+		}
+	}
+
+	for _, entry := range entries {
+		if err := crashmonitor.RemoveJournalEntry(dir, entry.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries, err := crashmonitor.ReadJournal(dir); err != nil || len(entries) != 0 {
+		t.Errorf("after removal, ReadJournal returned %d entries (err=%v), want none", len(entries), err)
+	}
+}
+
+func TestJournalCap(t *testing.T) {
+	crashmonitor.SetMaxJournalEntries(3)
+	defer crashmonitor.SetMaxJournalEntries(32)
+
+	dir := telemetry.NewDir(t.TempDir())
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("crash/crash\nmain.f%d:+1", i)
+		if err := crashmonitor.SaveCrash(dir, name, "panic", []byte("panic: oops\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond) // ensure distinct times
+	}
+	entries, err := crashmonitor.ReadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Counter)
+	}
+	want := []string{"crash/crash\nmain.f4:+1", "crash/crash\nmain.f3:+1", "crash/crash\nmain.f2:+1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("journal holds %q, want %q", got, want)
+	}
+}
+
+func TestUpdateLoopState(t *testing.T) {
+	dir := telemetry.NewDir(t.TempDir())
+	const name = "crash/crash\nmain.main:+1"
+	now := time.Now()
+	for i := 1; i <= crashmonitor.MaxCrashesPerPeriod+2; i++ {
+		n, err := crashmonitor.UpdateLoopState(dir, name, now.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("crash %d: UpdateLoopState returned %d", i, n)
+		}
+	}
+
+	// Other counters are limited separately.
+	if n, err := crashmonitor.UpdateLoopState(dir, "crash/crash\nmain.other:+1", now); err != nil || n != 1 {
+		t.Errorf("UpdateLoopState(other) = %d, %v, want 1", n, err)
+	}
+
+	// The count restarts in the next period.
+	if n, err := crashmonitor.UpdateLoopState(dir, name, now.Add(8*24*time.Hour)); err != nil || n != 1 {
+		t.Errorf("UpdateLoopState(next week) = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file limits the counting of crash loops, in which a program
+// crashes repeatedly with the same stack, for example because an editor
+// restarts a tool that crashes at startup. Without a limit, a single
+// machine could dominate the weekly totals of a crash counter.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// maxCrashesPerPeriod is the number of crashes with the same counter name
+// that are counted in each loopPeriod. Further crashes increment the
+// crash/loop counter, once per counter name and period.
+// (Mutable for testing.)
+var maxCrashesPerPeriod = 10
+
+// loopPeriod is the period over which crashes are limited, which matches
+// the usual lifetime of a counter file.
+const loopPeriod = 7 * 24 * time.Hour
+
+// loopState is the state of a counter name, saved in the crash directory.
+type loopState struct {
+	Start time.Time // start of the current period
+	Count int       // number of crashes in the period
+}
+
+// updateLoopState records a crash with the given counter name at time now in
+// the loop state of dir, and returns the number of crashes with that name
+// in the current period, including this one.
+//
+// Concurrent crashes may race to update the state, in which case some
+// crashes may go unrecorded; the limit is approximate.
+func updateLoopState(dir telemetry.Dir, counterName string, now time.Time) (int, error) {
+	crashDir := dir.CrashDir()
+	if err := os.MkdirAll(crashDir, 0777); err != nil {
+		return 0, err
+	}
+	fname := filepath.Join(crashDir, journalID(counterName)+".state")
+	var state loopState
+	if data, err := os.ReadFile(fname); err == nil {
+		_ = json.Unmarshal(data, &state)
+	}
+	if now.Before(state.Start) || now.Sub(state.Start) >= loopPeriod {
+		state = loopState{Start: now.UTC()}
+	}
+	state.Count++
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(fname, data, 0666); err != nil {
+		return 0, err
+	}
+	return state.Count, nil
+}
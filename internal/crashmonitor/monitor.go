@@ -8,17 +8,20 @@ package crashmonitor
 // crashes to telemetry.
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"reflect"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/telemetry"
 )
 
 // Supported reports whether the runtime supports [runtime/debug.SetCrashOutput].
@@ -31,7 +34,7 @@ var setCrashOutput func(*os.File) error // = runtime/debug.SetCrashOutput on go1
 // Parent sets up the parent side of the crashmonitor. It requires
 // exclusive use of a writable pipe connected to the child process's stdin.
 func Parent(pipe *os.File) {
-	writeSentinel(pipe)
+	writeHeader(pipe)
 	// Ensure that we get pc=0x%x values in the traceback.
 	debug.SetTraceback("system")
 	setCrashOutput(pipe)
@@ -41,24 +44,112 @@ func Parent(pipe *os.File) {
 // It expects its stdin to be connected via a pipe to the parent which has
 // run Parent.
 func Child() {
+	child(telemetryCounterName)
+}
+
+// ChildForExecutable is like [Child], but for a parent process running the
+// executable at path exe, which need not be the executable of the current
+// process. Crash PCs are symbolized using the symbol table of exe.
+//
+// If exe cannot be read, ChildForExecutable records crashes using only the
+// crash/unsymbolized counter.
+func ChildForExecutable(exe string) {
+	syms, err := openExecutable(exe)
+	if err == nil && syms.sentinel == 0 {
+		err = fmt.Errorf("no crashmonitor sentinel function")
+	}
+	if err != nil {
+		log.Printf("cannot symbolize crashes of %s: %v", exe, err)
+		child(func([]byte) (string, error) { return "crash/unsymbolized", nil })
+		return
+	}
+	child(syms.telemetryCounterName)
+}
+
+// ReportExecutableCrash records in telemetry a crash of a process running
+// the executable at path exe, which printed the given crash report with
+// GOTRACEBACK=system. Unlike [ChildForExecutable], it does not require the
+// process to have called [Parent]: crash PCs are symbolized as by
+// [SymbolizeCrash].
+//
+// If exe cannot be read, the crash is recorded using only the
+// crash/unsymbolized counter.
+func ReportExecutableCrash(exe string, crash []byte) error {
+	counterName := func([]byte) (string, error) { return "crash/unsymbolized", nil }
+	if syms, err := openExecutable(exe); err != nil {
+		log.Printf("cannot symbolize crashes of %s: %v", exe, err)
+	} else {
+		counterName = syms.counterName
+	}
+	return reportCrash(crash, counterName)
+}
+
+func child(counterName func(crash []byte) (string, error)) {
 	// Wait for parent process's dying gasp.
 	// If the parent dies for any reason this read will return.
-	data, err := io.ReadAll(os.Stdin)
+	data, err := readCrash(os.Stdin)
 	if err != nil {
-		log.Fatalf("failed to read from input pipe: %v", err)
+		var perr *protocolError
+		if !errors.As(err, &perr) {
+			log.Fatalf("failed to read from input pipe: %v", err)
+		}
+		// Keep count of each kind of failure, so that we can
+		// investigate if necessary.
+		incrementCounter("crash/protocol:" + perr.kind)
+		log.Print(err)
 	}
-
-	// If the only line is the sentinel, it wasn't a crash.
-	if bytes.Count(data, []byte("\n")) < 2 {
+	if data == nil {
 		childExitHook()
-		os.Exit(0) // parent exited without crash report
+		os.Exit(0) // parent exited without (readable) crash report
 	}
 
 	log.Printf("parent reported crash:\n%s", data)
 
+	err = reportCrash(data, counterName)
+	childExitHook()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatalf("telemetry crash recorded")
+}
+
+// reportCrash records a crash with the given crash report in telemetry,
+// using counterName to compute the name of its stack counter. It returns a
+// non-nil error if the crash could not be recorded normally.
+func reportCrash(data []byte, counterName func(crash []byte) (string, error)) error {
 	// Parse the stack out of the crash report
 	// and record a telemetry count for it.
-	name, err := telemetryCounterName(data)
+	kind := crashKind(data)
+	name, err := counterName(data)
+
+	// The local state of the crash monitor is kept only when telemetry
+	// is enabled.
+	local := false
+	if mode, _ := telemetry.Default.Mode(); (mode == "local" || mode == "on" || mode == "review") && !telemetry.Default.ReadOnly() {
+		local = true
+	}
+
+	// Stop counting a crash that recurs too often, such as a program that
+	// crashes at startup and is restarted in a loop.
+	if local {
+		key := name
+		if err != nil {
+			key = "crash/malformed"
+		}
+		n, err := updateLoopState(telemetry.Default, key, time.Now())
+		if err != nil {
+			log.Printf("failed to record crash loop state: %v", err)
+		} else if n > maxCrashesPerPeriod {
+			if n == maxCrashesPerPeriod+1 {
+				incrementCounter("crash/loop")
+			}
+			return fmt.Errorf("crash loop: %d crashes with the same stack; crash not recorded", n)
+		}
+	}
+
+	// Record the kind of crash, whether or not its stack can be parsed.
+	incrementCounter("crash/kind:" + kind)
+
 	if err != nil {
 		// Keep count of how often this happens
 		// so that we can investigate if necessary.
@@ -66,24 +157,29 @@ func Child() {
 
 		// Something went wrong.
 		// Save the crash securely in the file system.
-		f, err := os.CreateTemp(os.TempDir(), "*.crash")
-		if err != nil {
-			log.Fatal(err)
+		f, ferr := os.CreateTemp(os.TempDir(), "*.crash")
+		if ferr != nil {
+			return ferr
 		}
-		if _, err := f.Write(data); err != nil {
-			log.Fatal(err)
+		if _, ferr := f.Write(data); ferr != nil {
+			return ferr
 		}
-		if err := f.Close(); err != nil {
-			log.Fatal(err)
+		if ferr := f.Close(); ferr != nil {
+			return ferr
 		}
-		log.Printf("failed to report crash to telemetry: %v", err)
-		log.Fatalf("crash report saved at %s", f.Name())
+		return fmt.Errorf("failed to report crash to telemetry: %v\ncrash report saved at %s", err, f.Name())
 	}
 
 	incrementCounter(name)
 
-	childExitHook()
-	log.Fatalf("telemetry crash recorded")
+	// Save a copy of the crash report, so that users may inspect the
+	// crashes behind the counter (see gotelemetry crashes).
+	if local {
+		if err := saveCrash(telemetry.Default, name, kind, data); err != nil {
+			log.Printf("failed to save crash report: %v", err)
+		}
+	}
+	return nil
 }
 
 // (stubbed by test)
@@ -111,102 +207,351 @@ func writeSentinel(out io.Writer) {
 // converts each line into telemetry form ("symbol:relative-line"),
 // and returns this as the name of a counter.
 func telemetryCounterName(crash []byte) (string, error) {
-	pcs, err := parseStackPCs(string(crash))
+	return crashCounterName(crash, func(parentSentinel uint64, frames []tracebackFrame, prefix string) (string, error) {
+		if parentSentinel == 0 {
+			return "", fmt.Errorf("no sentinel value in crash report")
+		}
+		// Correct for the parent and child's different mappings of
+		// the text section.
+		childSentinel := sentinel()
+		adjusted := make([]uintptr, len(frames))
+		for i, fr := range frames {
+			adjusted[i] = uintptr(fr.pc - parentSentinel + childSentinel)
+		}
+		return encodeCrashFrames(counter.StackFrames(adjusted), prefix), nil
+	})
+}
+
+// crashKinds holds the kinds of crash reported by the crash/kind counter,
+// in order of precedence, each with a predicate on the lines of the crash
+// report that precede the first goroutine stack.
+var crashKinds = []struct {
+	kind  string
+	match func(line string) bool
+}{
+	{"nil-deref", func(line string) bool {
+		return strings.HasPrefix(line, "panic: runtime error: invalid memory address or nil pointer dereference")
+	}},
+	{"map-race", func(line string) bool {
+		return strings.HasPrefix(line, "fatal error: concurrent map ")
+	}},
+	{"oom", func(line string) bool {
+		return line == "fatal error: runtime: out of memory" ||
+			line == "fatal error: out of memory" ||
+			strings.HasPrefix(line, "runtime: out of memory:")
+	}},
+	{"stack-overflow", func(line string) bool {
+		return line == "fatal error: stack overflow"
+	}},
+	{"deadlock", func(line string) bool {
+		return strings.HasPrefix(line, "fatal error: all goroutines are asleep - deadlock!")
+	}},
+	{"panic", func(line string) bool {
+		return strings.HasPrefix(line, "panic: ")
+	}},
+	{"signal", func(line string) bool {
+		// e.g. "SIGSEGV: segmentation violation" (a signal in non-Go code),
+		// "[signal SIGSEGV: ...]", or "fatal error: unexpected signal ...".
+		sig, _, ok := strings.Cut(line, ": ")
+		return ok && strings.HasPrefix(sig, "SIG") && strings.ToUpper(sig) == sig ||
+			strings.HasPrefix(line, "[signal ") ||
+			strings.HasPrefix(line, "fatal error: unexpected signal")
+	}},
+}
+
+// crashKind classifies a crash report produced by the Go runtime as one of
+// panic, nil-deref, map-race, oom, stack-overflow, deadlock, signal, or
+// other, according to the messages that precede the goroutine stacks.
+//
+// Like crashCounterName, crashKind returns only one of a fixed set of strings,
+// so no part of the crash report can leak into the telemetry system.
+func crashKind(crash []byte) string {
+	var header []string
+	for _, line := range strings.Split(string(crash), "\n") {
+		if strings.HasPrefix(line, "goroutine ") {
+			break
+		}
+		header = append(header, line)
+	}
+	for _, k := range crashKinds {
+		for _, line := range header {
+			if k.match(line) {
+				return k.kind
+			}
+		}
+	}
+	return "other"
+}
+
+// crashPrefix appears at the start of all crashmonitor-generated
+// stack counter names.
+//
+// It is tempting to expose this as a parameter of Start, but
+// it is not without risk. What value should most programs
+// provide? There's no point giving the name of the executable
+// as this is already recorded by telemetry. What if the
+// application runs in multiple modes? Then it might be useful
+// to record the mode. The problem is that an application with
+// multiple modes probably doesn't know its mode by line 1 of
+// main.main: it might require flag or argument parsing, or
+// even validation of an environment variable, and we really
+// want to steer users aware from any logic before Start. The
+// flags and arguments will be wrong in the child process, and
+// every extra conditional branch creates a risk that the
+// recursively executed child program will behave not like the
+// monitor but like the application. If the child process
+// exits before calling Start, then the parent application
+// will not have a monitor, and its crash reports will be
+// discarded (written in to a pipe that is never read).
+//
+// So for now, we use this constant string.
+const crashPrefix = "crash/crash"
+
+// encodeCrashFrames returns the name of the stack counter with the given
+// prefix for the frames of a crashed or panicking goroutine.
+//
+// The line of the runtime.gopanic frame is omitted: it is the call of
+// fatalpanic for an unrecovered panic, but the call of the deferred
+// function for a recovered one, and the stacks of both should be recorded
+// by counters of the same name, apart from the prefix.
+func encodeCrashFrames(frames []counter.Frame, prefix string) string {
+	for i := range frames {
+		if frames[i].Function == "runtime.gopanic" {
+			frames[i].Line = frames[i].EntryLine
+		}
+	}
+	return counter.EncodeFrames(frames, prefix)
+}
+
+// An encodeFunc returns the name of a stack counter with the given
+// prefix for the frames of a traceback, using the parent's sentinel
+// value, if any, to relate their PCs to the executable's text segment.
+type encodeFunc func(parentSentinel uint64, frames []tracebackFrame, prefix string) (string, error)
+
+// crashCounterName returns the name of the counter for the given crash
+// report, using encode to convert the parent's stack frames into a stack
+// counter name.
+//
+// The function names of the frames in the crash report (which may
+// contain PII) are never recorded directly: encode must symbolize them
+// from their PCs or validate them against the executable.
+func crashCounterName(crash []byte, encode encodeFunc) (string, error) {
+	parentSentinel, goroutines, err := parseTraceback(string(crash))
 	if err != nil {
 		return "", err
 	}
+	for _, g := range goroutines {
+		if g.status == "running" && len(g.frames) > 0 {
+			// Limit the number of frames we request.
+			frames := g.frames[:min(len(g.frames), 16)]
+			return encode(parentSentinel, frames, crashPrefix)
+		}
+	}
+
+	// No goroutine is running. This can occur if all goroutines
+	// are idle, as when caught in a deadlock, or killed by an
+	// async signal while blocked. Reporting a single goroutine in
+	// [sleep] or [select] state could be quite confusing, as the
+	// problem is not local to one code location, so we report the
+	// most common states of the program's blocked goroutines.
+	prefix := "crash/blocked"
+	if crashKind(crash) == "deadlock" {
+		prefix = "crash/deadlock"
+	}
+	return blockedCounterName(parentSentinel, goroutines, prefix, encode)
+}
+
+// Limits on the signature of the blocked goroutines of a crash.
+const (
+	maxBlockedGroups = 3 // number of wait reasons reported
+	maxBlockedFrames = 8 // number of frames reported per wait reason
+)
 
-	// Limit the number of frames we request.
-	pcs = pcs[:min(len(pcs), 16)]
-
-	if len(pcs) == 0 {
-		// This can occur if all goroutines are idle, as when
-		// caught in a deadlock, or killed by an async signal
-		// while blocked.
-		//
-		// TODO(adonovan): consider how to report such
-		// situations. Reporting a goroutine in [sleep] or
-		// [select] state could be quite confusing without
-		// further information about the nature of the crash,
-		// as the problem is not local to the code location.
-		//
-		// For now, we keep count of this situation so that we
-		// can access whether it needs a more involved solution.
+// blockedCounterName returns the name of a counter that records the
+// blocked goroutines of a crash in which no goroutine was running.
+//
+// The goroutines that are executing code outside the runtime are grouped
+// by wait reason, and a representative stack of each of the (at most)
+// maxBlockedGroups largest groups is recorded, in decreasing order of
+// group size, each preceded by its wait reason:
+//
+//	crash/deadlock
+//	[chan receive]
+//	main.worker:+3
+//	...
+//	[sync.Mutex.Lock]
+//	main.main:+12
+//	...
+//
+// If there are no such goroutines, the counter is
+// crash/no-running-goroutine.
+func blockedCounterName(parentSentinel uint64, goroutines []tracebackGoroutine, prefix string, encode encodeFunc) (string, error) {
+	type group struct {
+		reason string
+		frames []tracebackFrame // of the first goroutine in the group
+		count  int
+	}
+	var groups []*group
+	byReason := make(map[string]*group)
+	for _, g := range goroutines {
+		// Skip the runtime frames of a blocked goroutine, such as
+		// runtime.gopark, which are the same for all goroutines
+		// with a given wait reason.
+		frames := g.frames
+		for len(frames) > 0 && strings.HasPrefix(frames[0].function, "runtime.") {
+			frames = frames[1:]
+		}
+		if len(frames) == 0 {
+			continue // a runtime goroutine
+		}
+		reason := waitReason(g.status)
+		gr := byReason[reason]
+		if gr == nil {
+			gr = &group{reason: reason, frames: frames[:min(len(frames), maxBlockedFrames)]}
+			byReason[reason] = gr
+			groups = append(groups, gr)
+		}
+		gr.count++
+	}
+	if len(groups) == 0 {
 		return "crash/no-running-goroutine", nil
 	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+	groups = groups[:min(len(groups), maxBlockedGroups)]
+
+	name := prefix
+	for _, gr := range groups {
+		stack, err := encode(parentSentinel, gr.frames, "["+gr.reason+"]")
+		if err != nil {
+			return "", err
+		}
+		name += "\n" + stack
+	}
+	return counter.TruncateName(name), nil
+}
 
-	// This string appears at the start of all
-	// crashmonitor-generated counter names.
-	//
-	// It is tempting to expose this as a parameter of Start, but
-	// it is not without risk. What value should most programs
-	// provide? There's no point giving the name of the executable
-	// as this is already recorded by telemetry. What if the
-	// application runs in multiple modes? Then it might be useful
-	// to record the mode. The problem is that an application with
-	// multiple modes probably doesn't know its mode by line 1 of
-	// main.main: it might require flag or argument parsing, or
-	// even validation of an environment variable, and we really
-	// want to steer users aware from any logic before Start. The
-	// flags and arguments will be wrong in the child process, and
-	// every extra conditional branch creates a risk that the
-	// recursively executed child program will behave not like the
-	// monitor but like the application. If the child process
-	// exits before calling Start, then the parent application
-	// will not have a monitor, and its crash reports will be
-	// discarded (written in to a pipe that is never read).
-	//
-	// So for now, we use this constant string.
-	const prefix = "crash/crash"
-	return counter.EncodeStack(pcs, prefix), nil
+// waitReason returns the wait reason of a goroutine with the given
+// status, such as "chan receive" for "chan receive, 2 minutes".
+// As the status is a string from the crash report, which a monitored
+// program can write at will, it is returned only if it is one of the
+// runtime's wait reasons in [waitReasons]; otherwise the result is "other".
+func waitReason(status string) string {
+	reason, _, _ := strings.Cut(status, ",")
+	if !waitReasons[reason] {
+		return "other"
+	}
+	return reason
 }
 
-// parseStackPCs parses the parent process's program counters for the
-// first running goroutine out of a GOTRACEBACK=system traceback,
-// adjusting them so that they are valid for the child process's text
-// segment.
+// waitReasons is the set of goroutine statuses printed by the runtime
+// (see waitReasonStrings in runtime/runtime2.go) that may be recorded.
+var waitReasons = map[string]bool{
+	"GC assist marking":             true,
+	"GC assist wait":                true,
+	"GC sweep wait":                 true,
+	"GC scavenge wait":              true,
+	"GC worker (idle)":              true,
+	"GC weak to strong wait":        true,
+	"IO wait":                       true,
+	"chan receive":                  true,
+	"chan receive (nil chan)":       true,
+	"chan receive (durable)":        true,
+	"chan send":                     true,
+	"chan send (nil chan)":          true,
+	"chan send (durable)":           true,
+	"cleanup wait":                  true,
+	"coroutine":                     true,
+	"finalizer wait":                true,
+	"force gc (idle)":               true,
+	"idle":                          true,
+	"preempted":                     true,
+	"runnable":                      true,
+	"select":                        true,
+	"select (no cases)":             true,
+	"select (durable)":              true,
+	"semacquire":                    true,
+	"sleep":                         true,
+	"sync.Cond.Wait":                true,
+	"sync.Mutex.Lock":               true,
+	"sync.RWMutex.Lock":             true,
+	"sync.RWMutex.RLock":            true,
+	"sync.WaitGroup.Wait":           true,
+	"sync.WaitGroup.Wait (durable)": true,
+	"synctest.Run":                  true,
+	"synctest.Wait":                 true,
+	"syscall":                       true,
+	"wait for GC cycle":             true,
+	"waiting":                       true,
+}
+
+// A tracebackFrame is a stack frame in a traceback.
+type tracebackFrame struct {
+	function string // function name, as printed in the traceback
+	relPC    uint64 // PC relative to the function's entry
+	pc       uint64 // absolute PC
+}
+
+// A tracebackGoroutine is the stack of a goroutine in a traceback.
+type tracebackGoroutine struct {
+	status string // e.g. "running", or "chan receive, 2 minutes"
+	frames []tracebackFrame
+}
+
+// parseTraceback parses the goroutine stacks out of a GOTRACEBACK=system
+// traceback, along with the sentinel value, if any.
 //
-// This function returns only program counter values, ensuring that
-// there is no possibility of strings from the crash report (which may
-// contain PII) leaking into the telemetry system.
-func parseStackPCs(crash string) ([]uintptr, error) {
-	// getPC parses the PC out of a line of the form:
+// Frames without a PC, such as inlined frames, are omitted.
+//
+// The statuses and function names of the goroutines are strings from the
+// crash report, which may contain PII: callers must not record them in
+// telemetry without validating them.
+func parseTraceback(crash string) (sentinel uint64, _ []tracebackGoroutine, _ error) {
+	// parseFrame parses the PCs out of a line of the form:
 	//     \tFILE:LINE +0xRELPC sp=... fp=... pc=...
-	getPC := func(line string) (uint64, error) {
+	parseFrame := func(line string) (relPC, pc uint64, _ error) {
 		_, pcstr, ok := strings.Cut(line, " pc=") // e.g. pc=0x%x
 		if !ok {
-			return 0, fmt.Errorf("no pc= for stack frame: %s", line)
+			return 0, 0, fmt.Errorf("no pc= for stack frame: %s", line)
+		}
+		pc, err := strconv.ParseUint(pcstr, 0, 64) // 0 => allow 0x prefix
+		if err != nil {
+			return 0, 0, err
 		}
-		return strconv.ParseUint(pcstr, 0, 64) // 0 => allow 0x prefix
+		// The RELPC is missing if the PC is the function's entry.
+		if _, rest, ok := strings.Cut(line, " +0x"); ok {
+			relstr, _, _ := strings.Cut(rest, " ")
+			if relPC, err = strconv.ParseUint(relstr, 16, 64); err != nil {
+				return 0, 0, err
+			}
+		}
+		return relPC, pc, nil
 	}
 
 	var (
-		pcs            []uintptr
-		parentSentinel uint64
-		childSentinel  = sentinel()
-		on             = false // are we in the first running goroutine?
-		lines          = strings.Split(crash, "\n")
+		goroutines []tracebackGoroutine
+		g          *tracebackGoroutine // current goroutine, if any
+		lines      = strings.Split(crash, "\n")
 	)
 	for i := 0; i < len(lines); i++ {
 		line := lines[i]
 
 		// Read sentinel value.
-		if parentSentinel == 0 && strings.HasPrefix(line, "sentinel ") {
-			_, err := fmt.Sscanf(line, "sentinel %x", &parentSentinel)
+		if sentinel == 0 && strings.HasPrefix(line, "sentinel ") {
+			_, err := fmt.Sscanf(line, "sentinel %x", &sentinel)
 			if err != nil {
-				return nil, fmt.Errorf("can't read sentinel line")
+				return 0, nil, fmt.Errorf("can't read sentinel line")
 			}
 			continue
 		}
 
-		// Search for "goroutine GID [STATUS]"
-		if !on {
-			if strings.HasPrefix(line, "goroutine ") &&
-				strings.Contains(line, " [running]:") {
-				on = true
-
-				if parentSentinel == 0 {
-					return nil, fmt.Errorf("no sentinel value in crash report")
+		// Search for "goroutine GID [STATUS]:"
+		if g == nil {
+			if strings.HasPrefix(line, "goroutine ") && strings.HasSuffix(line, "]:") {
+				if j := strings.LastIndex(line, " ["); j > 0 {
+					goroutines = append(goroutines, tracebackGoroutine{
+						status: line[j+len(" [") : len(line)-len("]:")],
+					})
+					g = &goroutines[len(goroutines)-1]
 				}
 			}
 			continue
@@ -214,12 +559,17 @@ func parseStackPCs(crash string) ([]uintptr, error) {
 
 		// A blank line marks end of a goroutine stack.
 		if line == "" {
-			break
+			g = nil
+			continue
 		}
 
-		// Skip the final "created by SYMBOL in goroutine GID" part.
+		// Skip the final "created by SYMBOL in goroutine GID" part,
+		// up to the end of the goroutine stack.
 		if strings.HasPrefix(line, "created by ") {
-			break
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			continue
 		}
 
 		// Expect a pair of lines:
@@ -228,23 +578,26 @@ func parseStackPCs(crash string) ([]uintptr, error) {
 		// Note: SYMBOL may contain parens "pkg.(*T).method"
 		// The RELPC is sometimes missing.
 
-		// Skip the symbol(args) line.
-		i++
-		if i == len(lines) {
-			break
+		// Read the symbol(args) line.
+		function := line
+		if j := strings.LastIndexByte(line, '('); j > 0 && strings.HasSuffix(line, ")") {
+			function = line[:j]
+		}
+		if i+1 == len(lines) || !strings.HasPrefix(lines[i+1], "\t") {
+			continue // e.g. "...additional frames elided..."
 		}
+		i++
 		line = lines[i]
 
-		// Parse the PC, and correct for the parent and child's
-		// different mappings of the text section.
-		pc, err := getPC(line)
+		// Parse the PC.
+		relPC, pc, err := parseFrame(line)
 		if err != nil {
 			// Inlined frame, perhaps; skip it.
 			continue
 		}
-		pcs = append(pcs, uintptr(pc-parentSentinel+childSentinel))
+		g.frames = append(g.frames, tracebackFrame{function, relPC, pc})
 	}
-	return pcs, nil
+	return sentinel, goroutines, nil
 }
 
 func min(x, y int) int {
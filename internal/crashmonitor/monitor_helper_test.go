@@ -4,13 +4,41 @@
 
 package crashmonitor
 
+import (
+	"errors"
+	"fmt"
+)
+
 // This file opens back doors for testing.
 
 var (
 	WriteSentinel        = writeSentinel
 	TelemetryCounterName = telemetryCounterName
+	CrashKind            = crashKind
+	RecoveredCounterName = recoveredCounterName
+	SaveCrash            = saveCrash
+	UpdateLoopState      = updateLoopState
+	ReadCrash            = readCrash
+	WriteHeader          = writeHeader
 )
 
+// ProtocolErrorKind returns the kind of a protocol error returned by
+// ReadCrash, or "" if err is not one.
+func ProtocolErrorKind(err error) string {
+	var perr *protocolError
+	if errors.As(err, &perr) {
+		return perr.kind
+	}
+	return ""
+}
+
+// MaxCrashesPerPeriod is the limit on crashes with the same counter name.
+var MaxCrashesPerPeriod = maxCrashesPerPeriod
+
+func SetMaxJournalEntries(n int) {
+	maxJournalEntries = n
+}
+
 func SetIncrementCounter(f func(name string)) {
 	incrementCounter = f
 }
@@ -18,3 +46,35 @@ func SetIncrementCounter(f func(name string)) {
 func SetChildExitHook(f func()) {
 	childExitHook = f
 }
+
+// ExecutableCounterName is like TelemetryCounterName, but symbolizes the
+// crash using the symbol table of the executable file exe.
+func ExecutableCounterName(exe string, crash []byte) (string, error) {
+	syms, err := openExecutable(exe)
+	if err != nil {
+		return "", err
+	}
+	return syms.telemetryCounterName(crash)
+}
+
+// ExecutableRelativeCounterName is like ExecutableCounterName, but ignores
+// the sentinel, as when symbolizing an arbitrary executable.
+func ExecutableRelativeCounterName(exe string, crash []byte) (string, error) {
+	syms, err := openExecutable(exe)
+	if err != nil {
+		return "", err
+	}
+	return syms.relativeCounterName(crash)
+}
+
+// TracebackCounterName is like TelemetryCounterName, but names each frame
+// by its function and entry-relative PC, as printed in the crash report.
+func TracebackCounterName(crash []byte) (string, error) {
+	return crashCounterName(crash, func(_ uint64, frames []tracebackFrame, prefix string) (string, error) {
+		name := prefix
+		for _, fr := range frames {
+			name += fmt.Sprintf("\n%s+%#x", fr.function, fr.relPC)
+		}
+		return name, nil
+	})
+}
@@ -98,6 +98,308 @@ func TestViaStderr(t *testing.T) {
 	}
 }
 
+// TestViaStderrExecutable is like TestViaStderr, but symbolizes the crash
+// using the symbol table of the test executable, as a dedicated sidecar
+// process does.
+func TestViaStderrExecutable(t *testing.T) {
+	if !crashmonitor.ExecutableSupported() {
+		t.Skip("symbolizing executables is not supported on this platform")
+	}
+	_, _, stderr := runSelf(t, "via-stderr")
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := crashmonitor.ExecutableCounterName(exe, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExecutableCounterName(t, got, stderr)
+}
+
+// checkExecutableCounterName checks the counter name of the via-stderr
+// crash symbolized from the test executable, which must expand the
+// inlined call to grandchild and match the crash monitor's own name.
+func checkExecutableCounterName(t *testing.T, got string, stderr []byte) {
+	t.Helper()
+	want := "crash/crash\n" +
+		"runtime.gopanic:--\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.grandchild:=69\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.child:+2\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.TestMain:+9\n" +
+		"main.main:--\n" +
+		"runtime.main:--\n" +
+		"runtime.goexit:--"
+	if sanitized := sanitize(counter.DecodeStack(got)); sanitized != want {
+		t.Errorf("got counter name <<%s>>, want <<%s>>", sanitized, want)
+	}
+	if !crashmonitor.Supported() { // !go1.23
+		return
+	}
+	want, err := crashmonitor.TelemetryCounterName(stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got counter name <<%s>>, want crash monitor's <<%s>>", got, want)
+	}
+}
+
+// TestRecoveredCounterName asserts that the stack counter of a recovered
+// panic is named like that of the same fatal panic (see TestViaStderr).
+func TestRecoveredCounterName(t *testing.T) {
+	var got string
+	func() {
+		defer func() {
+			got = crashmonitor.RecoveredCounterName(recover())
+		}()
+		child() // this line is "TestRecoveredCounterName.func1:+4"
+	}() // this line is "TestRecoveredCounterName:+7"
+	got = sanitize(counter.DecodeStack(got))
+	want := "crash/recovered\n" +
+		"runtime.gopanic:--\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.grandchild:=69\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.child:+2\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.TestRecoveredCounterName.func1:+4\n" +
+		"golang.org/x/telemetry/internal/crashmonitor_test.TestRecoveredCounterName:+7\n" +
+		"testing.tRunner:--\n" +
+		"runtime.goexit:--"
+	if got != want {
+		t.Errorf("got counter name <<%s>>, want <<%s>>", got, want)
+	}
+
+	// Without a panic, there is no counter.
+	if got := crashmonitor.RecoveredCounterName(nil); got != "" {
+		t.Errorf("RecoveredCounterName(nil) = %q, want \"\"", got)
+	}
+}
+
+// TestRecoveredMatchesCrash asserts that the stack counters of a recovered
+// panic and of a fatal panic from the same location have the same name,
+// apart from the prefix.
+func TestRecoveredMatchesCrash(t *testing.T) {
+	if !crashmonitor.Supported() { // !go1.23
+		t.Skip("before go1.23, the traceback excluded PCs for inlined frames")
+	}
+	telemetryFile, _, stderr := runSelf(t, "recover-then-crash")
+	recovered, err := os.ReadFile(telemetryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := crashmonitor.TelemetryCounterName(stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, ok := strings.CutPrefix(crashed, "crash/crash\n")
+	if !ok {
+		t.Fatalf("crash counter name <<%s>> lacks the crash/crash prefix", crashed)
+	}
+	if got, want := string(recovered), "crash/recovered\n"+stack; got != want {
+		t.Errorf("got recovered counter name <<%s>>, want <<%s>>", got, want)
+	}
+}
+
+// TestCrashKind checks the classification of crash reports by the crash/kind
+// counter, using excerpts of runtime crash output.
+func TestCrashKind(t *testing.T) {
+	const stack = "goroutine 1 [running]:\n" +
+		"main.main()\n" +
+		"\t/tmp/x.go:5 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45ad7d\n"
+	for _, test := range []struct {
+		kind, crash string
+	}{
+		{"panic", "panic: oops\n\n" + stack},
+		{"panic", "panic: oops [recovered]\n\tpanic: again\n\n" + stack},
+		{"panic", "panic: runtime error: index out of range [3] with length 1\n\n" + stack},
+		{"panic", "panic: runtime error: integer divide by zero\n" +
+			"[signal SIGFPE: floating-point exception code=0x1 addr=0x45ad7d pc=0x45ad7d]\n\n" + stack},
+		{"nil-deref", "panic: runtime error: invalid memory address or nil pointer dereference\n" +
+			"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x45ad7d]\n\n" + stack},
+		{"map-race", "fatal error: concurrent map writes\n\n" + stack},
+		{"map-race", "fatal error: concurrent map read and map write\n\n" + stack},
+		{"map-race", "fatal error: concurrent map iteration and map write\n\n" + stack},
+		{"oom", "runtime: out of memory: cannot allocate 1099511627776-byte block (3997696 in use)\n" +
+			"fatal error: out of memory\n\n" + stack},
+		{"oom", "fatal error: runtime: out of memory\n\nruntime stack:\n" + stack},
+		{"stack-overflow", "runtime: goroutine stack exceeds 1000000000-byte limit\n" +
+			"runtime: sp=0xc020160398 stack=[0xc020160000, 0xc040160000]\n" +
+			"fatal error: stack overflow\n\nruntime stack:\n" + stack},
+		{"deadlock", "fatal error: all goroutines are asleep - deadlock!\n\n" +
+			"goroutine 1 [chan receive]:\nmain.main()\n"},
+		{"signal", "SIGSEGV: segmentation violation\nPC=0x7f3e1c2a1b2c m=0 sigcode=1 addr=0x0\n" +
+			"signal arrived during cgo execution\n\n" + stack},
+		{"signal", "SIGABRT: abort\nPC=0x45ad7d m=0 sigcode=0\n\n" + stack},
+		{"signal", "unexpected fault address 0xdeadbeef\nfatal error: fault\n" +
+			"[signal SIGSEGV: segmentation violation code=0x1 addr=0xdeadbeef pc=0x45ad7d]\n\n" + stack},
+		{"signal", "fatal error: unexpected signal during runtime execution\n\n" + stack},
+		{"other", "fatal error: sync: unlock of unlocked mutex\n\n" + stack},
+		{"other", "fatal error: runtime: split stack overflow\n\n" + stack},
+		{"other", ""},
+		// Only the messages before the first goroutine are considered.
+		{"other", stack + "\ngoroutine 2 [running]:\npanic: not a panic message\n"},
+	} {
+		crash := "sentinel 45ad60\n" + test.crash
+		if got := crashmonitor.CrashKind([]byte(crash)); got != test.kind {
+			t.Errorf("CrashKind(%q) = %s, want %s", crash, got, test.kind)
+		}
+	}
+}
+
+// TestBlockedCounterName checks the counter names of crashes in which no
+// goroutine is running, using excerpts of runtime crash output.
+func TestBlockedCounterName(t *testing.T) {
+	const (
+		deadlock = "fatal error: all goroutines are asleep - deadlock!\n\n"
+		quit     = "SIGQUIT: quit\nPC=0x471e21 m=0 sigcode=0\n\n"
+
+		runtimeG = "goroutine 2 gp=0xc000006c40 m=nil [force gc (idle)]:\n" +
+			"runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)\n" +
+			"\t/go/src/runtime/proc.go:474 +0xca fp=0xc00005efa8 sp=0xc00005ef88 pc=0x48d5aa\n" +
+			"runtime.goparkunlock(...)\n" +
+			"\t/go/src/runtime/proc.go:480\n" +
+			"runtime.forcegchelper()\n" +
+			"\t/go/src/runtime/proc.go:373 +0xb3 fp=0xc00005efe0 sp=0xc00005efa8 pc=0x45b2b3\n" +
+			"runtime.goexit({})\n" +
+			"\t/go/src/runtime/asm_amd64.s:1771 +0x1 fp=0xc00005efe8 sp=0xc00005efe0 pc=0x494ea1\n" +
+			"created by runtime.init.7 in goroutine 1\n" +
+			"\t/go/src/runtime/proc.go:361 +0x1a\n\n"
+		mutexG = "goroutine 1 gp=0xc000002380 m=nil [sync.Mutex.Lock]:\n" +
+			"runtime.gopark(0xbf2440?, 0xc00009c120?, 0x0?, 0xa0?, 0xc0000e7cd0?)\n" +
+			"\t/go/src/runtime/proc.go:474 +0xca fp=0xc0000e7c50 sp=0xc0000e7c30 pc=0x48d5aa\n" +
+			"runtime.semacquire1(0xc0000d8514, 0x0, 0x3, 0x2, 0x15)\n" +
+			"\t/go/src/runtime/sema.go:192 +0x21d fp=0xc0000e7cb8 sp=0xc0000e7c50 pc=0x46c1fd\n" +
+			"internal/sync.runtime_SemacquireMutex(0x1?, 0x0?, 0x45ce19?)\n" +
+			"\t/go/src/runtime/sema.go:113 +0x25 fp=0xc0000e7cf0 sp=0xc0000e7cb8 pc=0x48f025\n" +
+			"internal/sync.(*Mutex).lockSlow(0xc0000d8510)\n" +
+			"\t/go/src/internal/sync/mutex.go:149 +0x15d fp=0xc0000e7d40 sp=0xc0000e7cf0 pc=0x4a1f7d\n" +
+			"internal/sync.(*Mutex).Lock(...)\n" +
+			"\t/go/src/internal/sync/mutex.go:70\n" +
+			"sync.(*Mutex).Lock(...)\n" +
+			"\t/go/src/sync/mutex.go:46\n" +
+			"main.main()\n" +
+			"\t/tmp/x.go:20 +0x8d fp=0xc0000e7f50 sp=0xc0000e7d40 pc=0x4b0a4d\n" +
+			"runtime.main()\n" +
+			"\t/go/src/runtime/proc.go:285 +0x29d fp=0xc0000e7fe0 sp=0xc0000e7f50 pc=0x45a9bd\n" +
+			"runtime.goexit({})\n" +
+			"\t/go/src/runtime/asm_amd64.s:1771 +0x1 fp=0xc0000e7fe8 sp=0xc0000e7fe0 pc=0x494ea1\n\n"
+		chanG = "goroutine 7 gp=0xc000102000 m=nil [chan receive, 2 minutes]:\n" +
+			"runtime.gopark(0xbf2400?, 0xc00009a000?, 0x0?, 0x24?, 0xbf2410?)\n" +
+			"\t/go/src/runtime/proc.go:474 +0xca fp=0xc00005ef18 sp=0xc00005eef8 pc=0x48d5aa\n" +
+			"runtime.chanrecv(0xc00009e0e0, 0x0, 0x1)\n" +
+			"\t/go/src/runtime/chan.go:667 +0x445 fp=0xc00005ef90 sp=0xc00005ef18 pc=0x4091e5\n" +
+			"runtime.chanrecv1(0x0?, 0x0?)\n" +
+			"\t/go/src/runtime/chan.go:509 +0x12 fp=0xc00005efb8 sp=0xc00005ef90 pc=0x408d72\n" +
+			"main.worker(...)\n" +
+			"\t/tmp/x.go:30\n" +
+			"main.main.gowrap1()\n" +
+			"\t/tmp/x.go:15 +0x25 fp=0xc00005efe0 sp=0xc00005efb8 pc=0x4b0b05\n" +
+			"runtime.goexit({})\n" +
+			"\t/go/src/runtime/asm_amd64.s:1771 +0x1 fp=0xc00005efe8 sp=0xc00005efe0 pc=0x494ea1\n" +
+			"created by main.main in goroutine 1\n" +
+			"\t/tmp/x.go:15 +0x4a\n\n"
+		selectG = "goroutine 9 gp=0xc000102380 m=nil [select]:\n" +
+			"runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)\n" +
+			"\t/go/src/runtime/proc.go:474 +0xca fp=0xc00005fe88 sp=0xc00005fe68 pc=0x48d5aa\n" +
+			"runtime.selectgo(0xc00005ff88, 0xc00005ff68, 0x0?, 0x0, 0x0?, 0x1)\n" +
+			"\t/go/src/runtime/select.go:351 +0x837 fp=0xc00005ffb0 sp=0xc00005fe88 pc=0x46b4f7\n" +
+			"main.poll()\n" +
+			"\t/tmp/x.go:40 +0x9c fp=0xc00005ffe0 sp=0xc00005ffb0 pc=0x4b0c3c\n" +
+			"runtime.goexit({})\n" +
+			"\t/go/src/runtime/asm_amd64.s:1771 +0x1 fp=0xc00005ffe8 sp=0xc00005ffe0 pc=0x494ea1\n\n"
+		// A wait reason that is not printed by the runtime, though it
+		// looks like one.
+		oddG = "goroutine 10 gp=0xc000102540 m=nil [user jane.doe (admin)]:\n" +
+			"main.odd()\n" +
+			"\t/tmp/x.go:50 +0x10 fp=0xc00005ffe0 sp=0xc00005ffb0 pc=0x4b0d10\n\n"
+	)
+	const (
+		mutex = "[sync.Mutex.Lock]\n" +
+			"internal/sync.runtime_SemacquireMutex+0x25\n" +
+			"internal/sync.(*Mutex).lockSlow+0x15d\n" +
+			"main.main+0x8d\n" +
+			"runtime.main+0x29d\n" +
+			"runtime.goexit+0x1"
+		chanRecv = "[chan receive]\n" +
+			"main.main.gowrap1+0x25\n" +
+			"runtime.goexit+0x1"
+	)
+	for _, test := range []struct {
+		crash, want string
+	}{
+		{deadlock + mutexG + runtimeG + chanG + chanG + selectG,
+			"crash/deadlock\n" + chanRecv + "\n" + mutex + "\n" +
+				"[select]\nmain.poll+0x9c\nruntime.goexit+0x1"},
+		// At most three wait reasons are reported, the largest groups first.
+		{deadlock + oddG + mutexG + selectG + chanG + chanG,
+			"crash/deadlock\n" + chanRecv + "\n[other]\nmain.odd+0x10\n" + mutex},
+		{quit + runtimeG + chanG, "crash/blocked\n" + chanRecv},
+		{quit + runtimeG, "crash/no-running-goroutine"},
+		{"", "crash/no-running-goroutine"},
+	} {
+		got, err := crashmonitor.TracebackCounterName([]byte(test.crash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("counter name for\n%s\ngot <<%s>>, want <<%s>>", test.crash, got, test.want)
+		}
+	}
+}
+
+// TestViaStderrRelative is like TestViaStderrExecutable, but symbolizes
+// the crash without the sentinel, as for an executable that does not use
+// the crash monitor.
+func TestViaStderrRelative(t *testing.T) {
+	if !crashmonitor.ExecutableSupported() {
+		t.Skip("symbolizing executables is not supported on this platform")
+	}
+	_, _, stderr := runSelf(t, "via-stderr")
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remove the sentinel, which an arbitrary executable would not print.
+	_, crash, _ := bytes.Cut(stderr, []byte("\n"))
+	got, err := crashmonitor.ExecutableRelativeCounterName(exe, crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExecutableCounterName(t, got, stderr)
+}
+
+// TestSymbolizeCrash checks that a saved crash report, with or without
+// the sentinel, is symbolized as by the crash monitor.
+func TestSymbolizeCrash(t *testing.T) {
+	if !crashmonitor.ExecutableSupported() {
+		t.Skip("symbolizing executables is not supported on this platform")
+	}
+	_, _, crash := runSelf(t, "via-stderr")
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSentinel, err := crashmonitor.SymbolizeCrash(exe, crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := crashmonitor.ExecutableCounterName(exe, crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withSentinel != want {
+		t.Errorf("SymbolizeCrash = <<%s>>, want <<%s>>", withSentinel, want)
+	}
+
+	_, crash, _ = bytes.Cut(crash, []byte("\n")) // remove the sentinel
+	withoutSentinel, err := crashmonitor.SymbolizeCrash(exe, crash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutSentinel != want {
+		t.Errorf("SymbolizeCrash without sentinel = <<%s>>, want <<%s>>", withoutSentinel, want)
+	}
+}
+
 func waitForExitFile(t *testing.T, exitFile string) {
 	deadline := time.Now().Add(10 * time.Second)
 	for {
@@ -212,3 +514,33 @@ func sanitize(name string) string {
 	}
 	return strings.Join(lines, "\n")
 }
+
+// The recover-then-crash mode panics twice from the same location,
+// recovering the first time: the counter name of the recovered panic is
+// written to a file, and the crash is printed to stderr. It is entered
+// from init rather than TestMain, so as not to move the lines of the
+// functions above, which the tests record.
+func init() {
+	if os.Getenv("CRASHMONITOR_TEST_ENTRYPOINT") != "recover-then-crash" {
+		return
+	}
+	debug.SetTraceback("system")
+	crashmonitor.WriteSentinel(os.Stderr)
+	for _, recovering := range []bool{true, false} {
+		name := crashOrRecover(recovering)
+		os.WriteFile(os.Getenv("CRASHMONITOR_TELEMETRY_FILE"), []byte(name), 0666)
+	}
+	panic("unreachable")
+}
+
+// crashOrRecover calls child, which panics. If recovering is set, it
+// recovers, and returns the name of the counter for the recovered panic.
+func crashOrRecover(recovering bool) (name string) {
+	if recovering {
+		defer func() {
+			name = crashmonitor.RecoveredCounterName(recover())
+		}()
+	}
+	child()
+	return ""
+}
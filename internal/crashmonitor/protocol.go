@@ -0,0 +1,142 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file defines the protocol on the pipe from the parent process to
+// the crash monitor child process.
+//
+// When it starts monitoring, the parent writes a header frame:
+//
+//	crashmonitor VERSION LENGTH
+//	FIELDS
+//
+// where FIELDS is LENGTH bytes of "key value" lines; currently the only
+// field is "sentinel HEX", the parent's sentinel value.
+//
+// The header is followed by the crash report, if the parent crashes.
+// Only the header is framed. The report is written to the pipe by the
+// runtime itself (see [runtime/debug.SetCrashOutput]) as the parent
+// dies, so the parent can neither announce its length nor follow it with
+// a trailer: the report extends to the end of the input. A parent that
+// exits without crashing closes the pipe after the header.
+//
+// As a consequence, a truncated report can be detected only by its
+// content: the runtime ends every line with a newline, and always prints
+// at least one goroutine, so [readCrash] reports a report that lacks a
+// final newline or a goroutine header as truncated or malformed. A report
+// cut off exactly at a line boundary is not detected.
+//
+// Parents that predate this protocol write only a "sentinel HEX" line
+// before the crash report. The child still accepts this form.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	protocolMagic   = "crashmonitor"
+	protocolVersion = 1
+	maxHeaderLen    = 4 << 10
+)
+
+// writeHeader writes the header frame of the protocol to w.
+func writeHeader(w io.Writer) error {
+	fields := fmt.Sprintf("sentinel %x\n", sentinel())
+	_, err := fmt.Fprintf(w, "%s %d %d\n%s", protocolMagic, protocolVersion, len(fields), fields)
+	return err
+}
+
+// A protocolError is a failure to read a crash report from the parent.
+// Its kind is reported by the crash/protocol counter.
+type protocolError struct {
+	kind string // truncated-header, bad-header, bad-version, truncated, or no-traceback
+	msg  string
+}
+
+func (e *protocolError) Error() string {
+	return fmt.Sprintf("crash monitor protocol: %s: %s", e.kind, e.msg)
+}
+
+func protocolErrorf(kind, format string, args ...any) error {
+	return &protocolError{kind, fmt.Sprintf(format, args...)}
+}
+
+// readCrash reads the input from the parent process until it is closed,
+// and returns the parent's crash report, preceded by a line holding the
+// parent's sentinel value, or nil if the parent exited without crashing.
+//
+// As the crash report is not framed, truncation is detected heuristically
+// (see the top of this file). If the crash report is truncated, readCrash
+// returns the complete lines of the report along with a protocolError.
+func readCrash(r io.Reader) ([]byte, error) {
+	in := bufio.NewReader(r)
+
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, nil // parent exited before monitoring started
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !strings.HasSuffix(line, "\n") {
+		return nil, protocolErrorf("truncated-header", "incomplete header line %q", line)
+	}
+
+	var header []byte
+	if strings.HasPrefix(line, "sentinel ") {
+		header = []byte(line) // legacy parent
+	} else {
+		f := strings.Fields(line)
+		if len(f) != 3 || f[0] != protocolMagic {
+			return nil, protocolErrorf("bad-header", "unexpected header line %q", line)
+		}
+		version, err := strconv.Atoi(f[1])
+		if err != nil {
+			return nil, protocolErrorf("bad-header", "invalid version %q", f[1])
+		}
+		if version != protocolVersion {
+			return nil, protocolErrorf("bad-version", "unsupported version %d", version)
+		}
+		n, err := strconv.Atoi(f[2])
+		if err != nil || n < 0 || n > maxHeaderLen {
+			return nil, protocolErrorf("bad-header", "invalid header length %q", f[2])
+		}
+		header = make([]byte, n)
+		if _, err := io.ReadFull(in, header); err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, protocolErrorf("truncated-header", "header has fewer than %d bytes", n)
+		} else if err != nil {
+			return nil, err
+		}
+		var sentinel uint64
+		if _, err := fmt.Sscanf(string(header), "sentinel %x\n", &sentinel); err != nil {
+			return nil, protocolErrorf("bad-header", "invalid header fields %q", header)
+		}
+	}
+
+	crash, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if len(crash) == 0 {
+		return nil, nil // parent exited without crashing
+	}
+
+	// The runtime ends every line of a crash report with a newline,
+	// and always prints at least one goroutine.
+	var perr error
+	if !bytes.HasSuffix(crash, []byte("\n")) {
+		crash = crash[:bytes.LastIndexByte(crash, '\n')+1]
+		perr = protocolErrorf("truncated", "crash report is incomplete")
+	}
+	if !bytes.HasPrefix(crash, []byte("goroutine ")) && !bytes.Contains(crash, []byte("\ngoroutine ")) {
+		return nil, protocolErrorf("no-traceback", "input has no goroutine traceback:\n%s", crash)
+	}
+	return append(header, crash...), perr
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/telemetry/internal/crashmonitor"
+)
+
+// TestReadCrash checks the reading of crash reports from the parent
+// process, for well-formed, truncated, interleaved and empty inputs.
+func TestReadCrash(t *testing.T) {
+	var buf bytes.Buffer
+	if err := crashmonitor.WriteHeader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	header := buf.String()
+	_, sentinel, _ := strings.Cut(header, "\n")
+
+	const crash = "panic: oops\n\n" +
+		"goroutine 1 gp=0xc000002380 m=0 mp=0x5b6f40 [running]:\n" +
+		"main.main()\n" +
+		"\t/tmp/x.go:5 +0x1d fp=0xc000058f50 sp=0xc000058f28 pc=0x45ad7d\n"
+
+	for _, test := range []struct {
+		name, input string
+		want        string // crash report, or "" for none
+		kind        string // protocol error kind, or "" for none
+	}{
+		{"empty", "", "", ""},
+		{"no crash", header, "", ""},
+		{"crash", header + crash, sentinel + crash, ""},
+		{"legacy no crash", "sentinel 45ad60\n", "", ""},
+		{"legacy crash", "sentinel 45ad60\n" + crash, "sentinel 45ad60\n" + crash, ""},
+		{"truncated header line", header[:5], "", "truncated-header"},
+		{"truncated header fields", header[:len(header)-3], "", "truncated-header"},
+		{"bad header", "hello, world\n" + crash, "", "bad-header"},
+		{"bad length", "crashmonitor 1 x\n" + crash, "", "bad-header"},
+		{"bad fields", "crashmonitor 1 6\nhello\n" + crash, "", "bad-header"},
+		{"bad version", "crashmonitor 99 5\nhello" + crash, "", "bad-version"},
+		{"truncated crash", header + crash[:len(crash)-10],
+			sentinel + crash[:strings.LastIndex(crash[:len(crash)-10], "\n")+1], "truncated"},
+		{"truncated before traceback", header + crash[:20], "", "no-traceback"},
+		{"interleaved output only", header + "hello from another writer\n", "", "no-traceback"},
+		{"interleaved output and crash", header + "hello from another writer\n" + crash,
+			sentinel + "hello from another writer\n" + crash, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := crashmonitor.ReadCrash(strings.NewReader(test.input))
+			if kind := crashmonitor.ProtocolErrorKind(err); kind != test.kind {
+				t.Errorf("ReadCrash error = %v (kind %q), want kind %q", err, kind, test.kind)
+			}
+			if string(got) != test.want {
+				t.Errorf("ReadCrash = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
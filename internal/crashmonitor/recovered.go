@@ -0,0 +1,52 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file reports panics that the application recovered from, which the
+// crash monitor never sees.
+
+import (
+	"runtime"
+
+	"golang.org/x/telemetry/internal/counter"
+)
+
+// recoveredPrefix appears at the start of the names of the stack counters
+// of recovered panics. Apart from the prefix, the names are the same as
+// those of the crash/crash counter for an unrecovered panic from the same
+// location (see [encodeCrashFrames]).
+const recoveredPrefix = "crash/recovered"
+
+// ReportRecovered increments a stack counter for the panic being recovered,
+// if r, the result of recover, is non-nil. It must be called from the
+// function deferred by the panicking goroutine.
+func ReportRecovered(r any) {
+	if name := recoveredCounterName(r); name != "" {
+		counter.New(name).Inc()
+	}
+}
+
+// recoveredCounterName returns the name of the counter for the panic being
+// recovered, or "" if there is none.
+//
+// The stack starts at runtime.gopanic, as in the traceback of a fatal
+// panic: the frames of the deferred function and of the reporting
+// functions are skipped.
+func recoveredCounterName(r any) string {
+	if r == nil {
+		return "" // not panicking
+	}
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+	for i, pc := range pcs {
+		if fn := runtime.FuncForPC(pc - 1); fn != nil && fn.Name() == "runtime.gopanic" {
+			// Limit the number of frames as for fatal crashes.
+			pcs = pcs[i:]
+			pcs = pcs[:min(len(pcs), 16)]
+			return encodeCrashFrames(counter.StackFrames(pcs), recoveredPrefix)
+		}
+	}
+	return "" // not called by a deferred function during a panic
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crashmonitor
+
+// This file symbolizes crash PCs using the symbol table of an executable
+// file, for use when the crashed process is not running the same
+// executable as the crash monitor.
+//
+// If the executable links the crash monitor, the PCs are related to the
+// symbol table using the sentinel function. Otherwise, they are found
+// from the function names and entry-relative PCs in the traceback.
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/telemetry/internal/counter"
+)
+
+// ExecutableSupported reports whether crashes of another executable can be
+// symbolized on this platform; see [ChildForExecutable].
+//
+// Only ELF executables are currently supported.
+func ExecutableSupported() bool {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "netbsd", "openbsd", "dragonfly", "solaris", "illumos":
+		return true
+	}
+	return false
+}
+
+// sentinelSymbol is the symbol of the [sentinel] function.
+const sentinelSymbol = "golang.org/x/telemetry/internal/crashmonitor.sentinel"
+
+// exeSymbols holds the symbol and inlining tables of an executable.
+type exeSymbols struct {
+	tab      *gosym.Table
+	inl      *inlTables
+	sentinel uint64 // link-time address of the sentinel function, or 0 if none
+}
+
+// openExecutable reads the symbol and inlining tables of the executable
+// file at path exe.
+func openExecutable(exe string) (*exeSymbols, error) {
+	f, err := elf.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	text := f.Section(".text")
+	if text == nil {
+		return nil, fmt.Errorf("%s: no .text section", exe)
+	}
+	pclntab, err := elfPCLNTab(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", exe, err)
+	}
+	tab, err := gosym.NewTable(nil, gosym.NewLineTable(pclntab, text.Addr))
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading symbol table: %v", exe, err)
+	}
+	inl, err := readInlTables(f, pclntab, text.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", exe, err)
+	}
+	syms := &exeSymbols{tab: tab, inl: inl}
+	if fn := tab.LookupFunc(sentinelSymbol); fn != nil {
+		syms.sentinel = fn.Entry
+	}
+	return syms, nil
+}
+
+// elfPCLNTab returns the contents of the Go pc/line table of f.
+func elfPCLNTab(f *elf.File) ([]byte, error) {
+	if sect := f.Section(".gopclntab"); sect != nil {
+		return sect.Data()
+	}
+	// Externally linked and PIE executables may lack the section; find the
+	// table through the runtime's symbols instead.
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("no .gopclntab section or symbols: %v", err)
+	}
+	var start, end uint64
+	for _, sym := range syms {
+		switch sym.Name {
+		case "runtime.pclntab":
+			start = sym.Value
+		case "runtime.epclntab":
+			end = sym.Value
+		}
+	}
+	if start == 0 || end <= start {
+		return nil, fmt.Errorf("no pc/line table")
+	}
+	for _, sect := range f.Sections {
+		if sect.Addr <= start && end <= sect.Addr+sect.Size {
+			data, err := sect.Data()
+			if err != nil {
+				return nil, err
+			}
+			return data[start-sect.Addr : end-sect.Addr], nil
+		}
+	}
+	return nil, fmt.Errorf("pc/line table is not within a section")
+}
+
+// SymbolizeCrash returns the name of the stack counter for a crash report
+// printed with GOTRACEBACK=system by a process running the executable at
+// path exe, such as a report saved in a .crash file. It uses the same
+// function-relative encoding as the crash monitor, so that crashes may be
+// symbolized offline, or checked again later, and compared with the
+// counters recorded by the crash monitor.
+//
+// If both the report and exe contain the crash monitor's sentinel, the
+// PCs are related to exe using the sentinel; otherwise, they are found
+// from the function names and offsets in the traceback.
+func SymbolizeCrash(exe string, crash []byte) (string, error) {
+	syms, err := openExecutable(exe)
+	if err != nil {
+		return "", err
+	}
+	return syms.counterName(crash)
+}
+
+// counterName returns the name of the stack counter for the given crash
+// report, using the best available method; see [SymbolizeCrash].
+func (s *exeSymbols) counterName(crash []byte) (string, error) {
+	if s.sentinel != 0 {
+		if sentinel, _, err := parseTraceback(string(crash)); err == nil && sentinel != 0 {
+			return s.telemetryCounterName(crash)
+		}
+	}
+	return s.relativeCounterName(crash)
+}
+
+// telemetryCounterName is like the function of the same name in
+// monitor.go, but symbolizes the parent's PCs using the symbol table.
+func (s *exeSymbols) telemetryCounterName(crash []byte) (string, error) {
+	if s.sentinel == 0 {
+		return "", fmt.Errorf("executable has no crashmonitor sentinel function")
+	}
+	return crashCounterName(crash, func(parentSentinel uint64, tframes []tracebackFrame, prefix string) (string, error) {
+		if parentSentinel == 0 {
+			return "", fmt.Errorf("no sentinel value in crash report")
+		}
+		// Correct for the difference between the parent's run-time
+		// mapping of the text section and its link-time addresses.
+		pcs := make([]uint64, len(tframes))
+		for i, tf := range tframes {
+			pcs[i] = tf.pc - parentSentinel + s.sentinel
+		}
+		return encodeCrashFrames(s.frames(pcs), prefix), nil
+	})
+}
+
+// relativeCounterName is like telemetryCounterName, but does not require
+// a sentinel value: it finds the link-time PC of each frame from the name
+// of its function and its entry-relative PC. Frames of functions that are
+// not in the symbol table, such as instantiations of generic functions,
+// whose names are abbreviated in tracebacks, are recorded as "?".
+func (s *exeSymbols) relativeCounterName(crash []byte) (string, error) {
+	return crashCounterName(crash, func(_ uint64, tframes []tracebackFrame, prefix string) (string, error) {
+		var frames []counter.Frame
+		for _, tf := range tframes {
+			name := tf.function
+			if name == "panic" {
+				name = "runtime.gopanic" // the traceback abbreviates it
+			}
+			fn := s.tab.LookupFunc(name)
+			if fn == nil {
+				frames = append(frames, counter.Frame{Function: "?"})
+				continue
+			}
+			frames = append(frames, s.frames([]uint64{fn.Entry + tf.relPC})...)
+		}
+		return encodeCrashFrames(frames, prefix), nil
+	})
+}
+
+// frames symbolizes the given link-time PCs, which are return addresses.
+//
+// Like [runtime.CallersFrames], frames expands the calls inlined at each
+// PC, giving inlined frames absolute line numbers.
+func (s *exeSymbols) frames(pcs []uint64) []counter.Frame {
+	var frames []counter.Frame
+	for _, pc := range pcs {
+		pc-- // the call instruction
+		for _, call := range s.inl.inlinedCalls(pc) {
+			_, line, _ := s.tab.PCToLine(pc)
+			frames = append(frames, counter.Frame{Function: call.function, Line: line})
+			pc = call.parentPC
+		}
+		_, line, fn := s.tab.PCToLine(pc)
+		if fn == nil {
+			frames = append(frames, counter.Frame{Function: "?"})
+			continue
+		}
+		_, entryLine, _ := s.tab.PCToLine(fn.Entry)
+		frames = append(frames, counter.Frame{Function: fn.Name, Line: line, EntryLine: entryLine})
+	}
+	return frames
+}
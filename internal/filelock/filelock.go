@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package filelock provides advisory, cross-process locks on open files.
+//
+// Locks are held by an open file, not by a path: a lock is released when the
+// file is unlocked or closed, or when the process holding it exits. This
+// makes them suitable for coordinating work in the telemetry directory, where
+// a process may die at any time without cleaning up.
+package filelock
+
+import "errors"
+
+// ErrNotSupported is returned when file locking is not supported on the
+// current platform.
+var ErrNotSupported = errors.New("file locking is not supported on this platform")
+
+// Lock places an exclusive lock on the file f, blocking until it can be
+// acquired.
+func Lock(f File) error {
+	return lock(f, true, true)
+}
+
+// RLock places a shared lock on the file f, blocking until it can be
+// acquired. Any number of files may hold a shared lock at once, but not
+// while another holds an exclusive lock.
+//
+// On Windows, where a shared lock denies writes to the bytes it covers,
+// the shared lock covers only a byte beyond the end of any file, so that
+// its holders can still write the file.
+func RLock(f File) error {
+	return lock(f, false, true)
+}
+
+// TryLock attempts to place an exclusive lock on the file f without blocking.
+// It reports whether the lock was acquired.
+func TryLock(f File) (bool, error) {
+	err := lock(f, true, false)
+	if err == errLocked {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlock removes the lock, exclusive or shared, held on the file f.
+func Unlock(f File) error {
+	return unlock(f)
+}
+
+// A File is an open file, such as an [*os.File].
+type File interface {
+	Name() string
+	Fd() uintptr
+}
+
+// errLocked is returned by lock when the file is locked by another holder and
+// the caller asked not to block.
+var errLocked = errors.New("file is locked")
@@ -0,0 +1,50 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || illumos || solaris
+
+package filelock
+
+// These platforms lack flock, so use POSIX record locks instead.
+// Unlike flock locks, record locks are held by the process, not the open
+// file: they do not exclude other files opened by the same process, and
+// closing any file referring to the locked file releases the lock.
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+func lock(f File, exclusive, block bool) error {
+	cmd := unix.F_SETLK
+	if block {
+		cmd = unix.F_SETLKW
+	}
+	typ := int16(unix.F_RDLCK)
+	if exclusive {
+		typ = unix.F_WRLCK
+	}
+	lk := unix.Flock_t{Type: typ, Whence: 0, Start: 0, Len: 0} // whole file
+	for {
+		err := unix.FcntlFlock(f.Fd(), cmd, &lk)
+		switch err {
+		case nil:
+			return nil
+		case unix.EINTR:
+			continue
+		case unix.EAGAIN, unix.EACCES:
+			return errLocked
+		}
+		return &fs.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+	}
+}
+
+func unlock(f File) error {
+	lk := unix.Flock_t{Type: unix.F_UNLCK, Whence: 0, Start: 0, Len: 0}
+	if err := unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk); err != nil {
+		return &fs.PathError{Op: "fcntl", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris || windows)
+
+package filelock
+
+func lock(File, bool, bool) error { return ErrNotSupported }
+
+func unlock(File) error { return ErrNotSupported }
@@ -0,0 +1,94 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package filelock_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/telemetry/internal/filelock"
+)
+
+func TestTryLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "aix", "illumos", "solaris":
+		t.Skipf("record locks on %s do not exclude files opened by the same process", runtime.GOOS)
+	case "js", "plan9", "wasip1":
+		t.Skipf("file locking is not supported on %s", runtime.GOOS)
+	}
+
+	name := filepath.Join(t.TempDir(), "lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	f1, f2 := open(), open()
+
+	if ok, err := filelock.TryLock(f1); !ok || err != nil {
+		t.Fatalf("TryLock(f1) = %t, %v, want true, nil", ok, err)
+	}
+	if ok, err := filelock.TryLock(f2); ok || err != nil {
+		t.Fatalf("TryLock(f2) while f1 is locked = %t, %v, want false, nil", ok, err)
+	}
+	if err := filelock.Unlock(f1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := filelock.TryLock(f2); !ok || err != nil {
+		t.Fatalf("TryLock(f2) after unlocking f1 = %t, %v, want true, nil", ok, err)
+	}
+
+	// Closing the file releases its lock.
+	f2.Close()
+	if err := filelock.Lock(f1); err != nil {
+		t.Fatalf("Lock(f1) after closing f2 = %v", err)
+	}
+}
+
+func TestRLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "aix", "illumos", "solaris":
+		t.Skipf("record locks on %s do not exclude files opened by the same process", runtime.GOOS)
+	case "js", "plan9", "wasip1":
+		t.Skipf("file locking is not supported on %s", runtime.GOOS)
+	}
+
+	name := filepath.Join(t.TempDir(), "lock")
+	open := func() *os.File {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	f1, f2, f3 := open(), open(), open()
+
+	if err := filelock.RLock(f1); err != nil {
+		t.Fatalf("RLock(f1) = %v", err)
+	}
+	if err := filelock.RLock(f2); err != nil {
+		t.Fatalf("RLock(f2) while f1 is read-locked = %v", err)
+	}
+	// Holders of a shared lock can still write the file.
+	if _, err := f1.WriteAt([]byte("data"), 0); err != nil {
+		t.Fatalf("writing read-locked f1: %v", err)
+	}
+	if ok, err := filelock.TryLock(f3); ok || err != nil {
+		t.Fatalf("TryLock(f3) while f1 and f2 are read-locked = %t, %v, want false, nil", ok, err)
+	}
+	if err := filelock.Unlock(f1); err != nil {
+		t.Fatal(err)
+	}
+	f2.Close()
+	if ok, err := filelock.TryLock(f3); !ok || err != nil {
+		t.Fatalf("TryLock(f3) after releasing the shared locks = %t, %v, want true, nil", ok, err)
+	}
+}
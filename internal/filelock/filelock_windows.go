@@ -0,0 +1,61 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package filelock
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/windows"
+)
+
+// allBytes locks the whole file, however large it may grow.
+const allBytes = ^uint32(0)
+
+// sharedOverlapped returns the location of shared locks: the byte at
+// offset 2⁶⁴-2, the last one covered by exclusive locks. A shared lock
+// denies writes to the bytes it covers, so it covers none of the data of
+// a file.
+func sharedOverlapped() *windows.Overlapped {
+	return &windows.Overlapped{Offset: allBytes - 1, OffsetHigh: allBytes}
+}
+
+func lock(f File, exclusive, block bool) error {
+	var (
+		flags     uint32
+		ol        = new(windows.Overlapped)
+		low, high = allBytes, allBytes
+	)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	} else {
+		ol, low, high = sharedOverlapped(), 1, 0
+	}
+	if !block {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, low, high, ol)
+	switch err {
+	case nil:
+		return nil
+	case windows.ERROR_LOCK_VIOLATION:
+		return errLocked
+	}
+	return &fs.PathError{Op: "LockFileEx", Path: f.Name(), Err: err}
+}
+
+func unlock(f File) error {
+	ol := new(windows.Overlapped)
+	err := windows.UnlockFileEx(windows.Handle(f.Fd()), 0, allBytes, allBytes, ol)
+	if err == windows.ERROR_NOT_LOCKED {
+		// The lock is shared.
+		err = windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, sharedOverlapped())
+	}
+	if err != nil {
+		return &fs.PathError{Op: "UnlockFileEx", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -6,21 +6,30 @@ package regtest
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/telemetry/counter"
 	"golang.org/x/telemetry/internal/config"
+	"golang.org/x/telemetry/internal/configtest"
 	icounter "golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/reportio"
 	"golang.org/x/telemetry/internal/telemetry"
 	"golang.org/x/telemetry/internal/testenv"
+	"golang.org/x/telemetry/internal/upload"
 )
 
 func TestRunProg(t *testing.T) {
@@ -94,6 +103,25 @@ func TestE2E_off(t *testing.T) {
 	}
 }
 
+func TestE2E_newerLayout(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	prog := NewProgram(t, "prog", programIncCounters)
+	dir := telemetry.NewDir(t.TempDir())
+	if err := os.WriteFile(dir.LayoutFile(), []byte(fmt.Sprint(telemetry.LayoutVersion+1)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	out, err := RunProg(t, dir.Dir(), prog)
+	if err != nil {
+		t.Fatalf("program failed unexpectedly (%v)\n%s", err, out)
+	}
+	// An older library must not write into a directory with a newer layout.
+	if _, err := os.Stat(dir.LocalDir()); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) = %v, want not exist", dir.LocalDir(), err)
+	}
+}
+
 func TestE2E(t *testing.T) {
 	testenv.SkipIfUnsupportedPlatform(t)
 	testenv.MustHaveExec(t)
@@ -241,3 +269,128 @@ func parseCounters(uc *telemetry.UploadConfig, telemetryDir string) (uploadable,
 	}
 	return uploadable, notUploadable, nil
 }
+
+// TestUploadE2E checks that reports uploaded by the uploader are gzipped,
+// and that [reportio.ReadReport], which upload servers use to read them,
+// accepts both these reports and the plain reports of older clients, and
+// limits the size of decompressed reports.
+func TestUploadE2E(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+	testenv.NeedsGo(t)
+	prog := NewIncProgram(t, "prog", "counter")
+
+	telemetryDir := t.TempDir()
+	if out, err := RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	dir := telemetry.NewDir(telemetryDir)
+	if err := dir.SetModeAsOf("on", time.Now().Add(-365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server stores the reports it receives, by X, along with their
+	// content encoding. Like the telemetry.go.dev server, it limits the
+	// size of request bodies as well as that of decompressed reports.
+	const maxRequestBytes = 10 << 10
+	var (
+		mu        sync.Mutex
+		stored    = make(map[float64]*telemetry.Report)
+		encodings = make(map[float64]string)
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := reportio.ReadReport(w, r, maxRequestBytes)
+		if err != nil {
+			code := http.StatusBadRequest
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stored[report.X] = report
+		encodings[report.X] = r.Header.Get("Content-Encoding")
+	})
+	srv := httptest.NewServer(http.MaxBytesHandler(handler, maxRequestBytes))
+	t.Cleanup(srv.Close)
+
+	// The uploader sends gzipped reports.
+	uc := CreateTestUploadConfig(t, []string{"counter"}, nil)
+	if err := upload.Run(upload.RunConfig{
+		TelemetryDir: telemetryDir,
+		UploadURL:    srv.URL + "/upload",
+		Env:          configtest.LocalProxyEnv(t, uc, "v1.2.3"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := filepath.Glob(filepath.Join(dir.UploadDir(), "*-*-*.json")) // not status.json
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("got uploaded reports %v (err=%v), want 1", uploads, err)
+	}
+	data, err := os.ReadFile(uploads[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report telemetry.Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	got, enc := stored[report.X], encodings[report.X]
+	mu.Unlock()
+	if got == nil || len(got.Programs) != 1 || got.Programs[0].Counters["counter"] != 1 {
+		t.Fatalf("stored report is %s, want one program with counter=1", stringify(got))
+	}
+	if enc != "gzip" {
+		t.Errorf("uploaded report has content encoding %q, want gzip", enc)
+	}
+
+	post := func(body []byte, gzipped bool) int {
+		t.Helper()
+		req, err := http.NewRequest("POST", srv.URL+"/upload/"+report.Week, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Older clients send plain reports.
+	report.X = 0.5
+	plain, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := post(plain, false); code != http.StatusOK {
+		t.Errorf("plain upload: got status %d, want 200", code)
+	}
+	mu.Lock()
+	got, enc = stored[report.X], encodings[report.X]
+	mu.Unlock()
+	if got == nil || enc != "" {
+		t.Errorf("plain upload: stored report %s with content encoding %q, want a plain report", stringify(got), enc)
+	}
+
+	// The size limit applies after decompression.
+	padded := append(bytes.Repeat([]byte(" "), 2*maxRequestBytes), plain...)
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write(padded)
+	zw.Close()
+	if zbuf.Len() >= maxRequestBytes {
+		t.Fatalf("compressed padded report has %d bytes, want fewer than %d", zbuf.Len(), maxRequestBytes)
+	}
+	if code := post(zbuf.Bytes(), true); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized gzipped upload: got status %d, want 413", code)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package reportio reads the reports uploaded to upload servers, such as
+// telemetry.go.dev.
+package reportio
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// ErrUnsupportedEncoding is returned by [ReadReport] for an upload
+// request with an unknown Content-Encoding.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// ReadReport reads the report uploaded by the request r. It accepts both
+// the gzipped reports sent by the uploader and the plain reports of older
+// clients.
+//
+// The size of a decompressed report is limited to maxBytes; if it is
+// larger, the error wraps an [http.MaxBytesError]. Limiting the size of
+// the request body itself is left to the server.
+func ReadReport(w http.ResponseWriter, r *http.Request, maxBytes int64) (*telemetry.Report, error) {
+	body := r.Body
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip payload: %v", err)
+		}
+		defer zr.Close()
+		body = http.MaxBytesReader(w, zr, maxBytes)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, enc)
+	}
+	var report telemetry.Report
+	if err := json.NewDecoder(body).Decode(&report); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, fmt.Errorf("report exceeds %d bytes: %w", maxErr.Limit, err)
+		}
+		return nil, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+	return &report, nil
+}
@@ -30,7 +30,7 @@ var Default Dir
 
 // A Dir holds paths to telemetry data inside a directory.
 type Dir struct {
-	dir, local, upload, debug, modefile string
+	dir, local, upload, debug, crashes, modefile, layoutfile, configcache string
 }
 
 // NewDir creates a new Dir encapsulating paths in the given dir.
@@ -39,11 +39,14 @@ type Dir struct {
 // the telemetry directory layout.
 func NewDir(dir string) Dir {
 	return Dir{
-		dir:      dir,
-		local:    filepath.Join(dir, "local"),
-		upload:   filepath.Join(dir, "upload"),
-		debug:    filepath.Join(dir, "debug"),
-		modefile: filepath.Join(dir, "mode"),
+		dir:         dir,
+		local:       filepath.Join(dir, "local"),
+		upload:      filepath.Join(dir, "upload"),
+		debug:       filepath.Join(dir, "debug"),
+		crashes:     filepath.Join(dir, "local", "crashes"),
+		modefile:    filepath.Join(dir, "mode"),
+		layoutfile:  filepath.Join(dir, "layout"),
+		configcache: filepath.Join(dir, "configcache.json"),
 	}
 }
 
@@ -71,12 +74,27 @@ func (d Dir) DebugDir() string {
 	return d.debug
 }
 
+// CrashDir is the directory holding the journal of saved crash reports.
+func (d Dir) CrashDir() string {
+	return d.crashes
+}
+
 func (d Dir) ModeFile() string {
 	return d.modefile
 }
 
+// LayoutFile is the file recording the layout version of the directory.
+func (d Dir) LayoutFile() string {
+	return d.layoutfile
+}
+
+// ConfigCacheFile is the file caching the latest upload config.
+func (d Dir) ConfigCacheFile() string {
+	return d.configcache
+}
+
 // SetMode updates the telemetry mode with the given mode.
-// Acceptable values for mode are "on", "off", or "local".
+// Acceptable values for mode are "on", "off", "local", or "review".
 //
 // SetMode always writes the mode file, and explicitly records the date at
 // which the modefile was updated. This means that calling SetMode with "on"
@@ -90,7 +108,7 @@ func (d Dir) SetMode(mode string) error {
 func (d Dir) SetModeAsOf(mode string, asofTime time.Time) error {
 	mode = strings.TrimSpace(mode)
 	switch mode {
-	case "on", "off", "local":
+	case "on", "off", "local", "review":
 	default:
 		return fmt.Errorf("invalid telemetry mode: %q", mode)
 	}
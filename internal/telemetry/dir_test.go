@@ -6,9 +6,13 @@
 package telemetry
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"golang.org/x/telemetry/internal/filelock"
 )
 
 func TestDefaults(t *testing.T) {
@@ -50,6 +54,7 @@ func TestSetMode(t *testing.T) {
 		{"on", false},
 		{"off", false},
 		{"local", false},
+		{"review", false},
 		{"https://mytelemetry.com", true},
 		{"http://insecure.com", true},
 		{"bogus", true},
@@ -97,3 +102,70 @@ func TestMode(t *testing.T) {
 		})
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	dir := NewDir(t.TempDir())
+	if v, err := dir.Layout(); err != nil || v != 0 {
+		t.Fatalf("Layout() of fresh dir = %d, %v, want 0, nil", v, err)
+	}
+	if err := dir.Migrate(); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+	if v, err := dir.Layout(); err != nil || v != LayoutVersion {
+		t.Errorf("Layout() after Migrate = %d, %v, want %d, nil", v, err, LayoutVersion)
+	}
+	if dir.ReadOnly() {
+		t.Errorf("ReadOnly() = true after Migrate")
+	}
+	// Migrating again is a no-op.
+	if err := dir.Migrate(); err != nil {
+		t.Errorf("second Migrate() failed: %v", err)
+	}
+}
+
+func TestMigrateSteps(t *testing.T) {
+	defer func(saved []migration) { migrations = saved }(migrations)
+
+	var ran []int
+	migrations = nil
+	for i := 0; i < LayoutVersion; i++ {
+		from := i
+		migrations = append(migrations, migration{from, func(Dir) error {
+			ran = append(ran, from)
+			return nil
+		}})
+	}
+
+	dir := NewDir(t.TempDir())
+	if err := dir.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != LayoutVersion {
+		t.Errorf("ran migrations %v, want one per version below %d", ran, LayoutVersion)
+	}
+	f, err := os.Open(filepath.Join(dir.Dir(), "layout.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ok, err := filelock.TryLock(f); !ok {
+		t.Errorf("layout lock was not released: %v", err)
+	}
+}
+
+func TestMigrateNewerLayout(t *testing.T) {
+	dir := NewDir(t.TempDir())
+	if err := os.WriteFile(dir.LayoutFile(), []byte(fmt.Sprint(LayoutVersion+1)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if !dir.ReadOnly() {
+		t.Errorf("ReadOnly() = false for newer layout")
+	}
+	if err := dir.Migrate(); err != ErrNewerLayout {
+		t.Errorf("Migrate() = %v, want %v", err, ErrNewerLayout)
+	}
+	data, err := os.ReadFile(dir.LayoutFile())
+	if err != nil || string(data) != fmt.Sprint(LayoutVersion+1) {
+		t.Errorf("Migrate modified a newer layout file: %q, %v", data, err)
+	}
+}
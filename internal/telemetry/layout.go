@@ -0,0 +1,191 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/telemetry/internal/filelock"
+)
+
+// LayoutVersion is the version of the telemetry directory layout written by
+// this version of the library.
+//
+// The layout version covers the names and formats of everything inside the
+// telemetry directory other than the mode file, which must remain readable by
+// all versions. Whenever the layout changes in a way that older versions of
+// the library cannot safely read or write, LayoutVersion must be incremented
+// and a corresponding entry added to [migrations].
+//
+// Version 0 is the unversioned layout that predates the layout file: local,
+// upload and debug directories, and v1 counter files. Version 1 adds the
+// layout file.
+//
+// The following additions to version 1 are safe for libraries that only
+// know version 0, which read nothing but count files (*.v1.count) and
+// reports (*.json) directly in the local and upload directories:
+//
+//   - configcache.json, the cached upload config, is not read by them.
+//   - The local/crashes, local/pending, local/rejected, local/dest/NAME,
+//     upload/dest/NAME, and upload/imported directories are skipped by
+//     them, so that they never upload reports held for review, or reports
+//     meant for other destinations.
+//   - upload/status.json is taken by them for an uploaded report, which is
+//     harmless, as no report has that name.
+//   - local/*.expired-*.v1.count are flushed count files, which they
+//     report as they do any expired count file, though as full weeks.
+//     Their processes do not lock the count files they use, so flushing
+//     may expire a count file that one of them is still incrementing.
+const LayoutVersion = 1
+
+// ErrNewerLayout is returned by [Dir.Migrate] when the telemetry directory
+// was written by a newer version of the library. In that case the directory
+// must be treated as read-only.
+var ErrNewerLayout = errors.New("telemetry directory has a newer layout")
+
+// A migration upgrades a telemetry directory from layout version from to
+// version from+1.
+type migration struct {
+	from    int
+	migrate func(Dir) error
+}
+
+// migrations holds the sequence of layout migrations, ordered by from.
+// (Mutable for testing.)
+var migrations = []migration{
+	// 0 -> 1: the layout is unchanged; only the layout file is added.
+	{0, func(Dir) error { return nil }},
+}
+
+// Layout returns the layout version recorded in the telemetry directory.
+//
+// A directory with no layout file has version 0.
+func (d Dir) Layout() (int, error) {
+	if d.layoutfile == "" {
+		return 0, fmt.Errorf("cannot determine telemetry layout file name")
+	}
+	data, err := os.ReadFile(d.layoutfile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("malformed telemetry layout file %q", data)
+	}
+	return v, nil
+}
+
+// ReadOnly reports whether the telemetry directory must not be written to by
+// this version of the library, because it has a newer or unreadable layout.
+func (d Dir) ReadOnly() bool {
+	v, err := d.Layout()
+	return err != nil || v > LayoutVersion
+}
+
+// Migrate brings the telemetry directory up to [LayoutVersion], running any
+// required migrations while holding the directory's layout lock.
+//
+// If the directory has a newer layout than this library understands, Migrate
+// returns [ErrNewerLayout] and leaves the directory untouched. Callers must not
+// write data into the directory unless Migrate succeeds.
+func (d Dir) Migrate() error {
+	v, err := d.Layout()
+	if err != nil {
+		return err
+	}
+	if v == LayoutVersion {
+		return nil // fast path: no lock required
+	}
+	if v > LayoutVersion {
+		return ErrNewerLayout
+	}
+	if err := os.MkdirAll(d.dir, 0777); err != nil {
+		return fmt.Errorf("cannot create telemetry directory: %w", err)
+	}
+
+	unlock, err := d.lockLayout()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Re-read the version now that we hold the lock: another process may have
+	// migrated the directory in the meantime.
+	v, err = d.Layout()
+	if err != nil {
+		return err
+	}
+	if v > LayoutVersion {
+		return ErrNewerLayout
+	}
+	for v < LayoutVersion {
+		var migrate func(Dir) error
+		for _, m := range migrations {
+			if m.from == v {
+				migrate = m.migrate
+				break
+			}
+		}
+		if migrate == nil {
+			return fmt.Errorf("internal error: no migration from telemetry layout version %d", v)
+		}
+		if err := migrate(d); err != nil {
+			return fmt.Errorf("migrating telemetry layout from version %d: %w", v, err)
+		}
+		v++
+		// Record progress after each step, so that an interrupted sequence of
+		// migrations resumes where it left off.
+		if err := d.writeLayout(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLayout atomically records the layout version v.
+func (d Dir) writeLayout(v int) error {
+	tmp, err := os.CreateTemp(d.dir, "layout.*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := fmt.Fprintf(tmp, "%d\n", v)
+	cerr := tmp.Close()
+	if werr == nil {
+		werr = cerr
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), d.layoutfile)
+	}
+	if werr != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing telemetry layout file: %w", werr)
+	}
+	return nil
+}
+
+// lockLayout acquires the directory's layout lock, returning a function that
+// releases it. The lock file itself is left in place.
+func (d Dir) lockLayout() (unlock func(), _ error) {
+	f, err := os.OpenFile(filepath.Join(d.dir, "layout.lock"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire telemetry layout lock: %w", err)
+	}
+	if err := filelock.Lock(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to acquire telemetry layout lock: %w", err)
+	}
+	return func() {
+		filelock.Unlock(f)
+		f.Close()
+	}, nil
+}
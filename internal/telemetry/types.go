@@ -29,6 +29,9 @@ type CounterConfig struct {
 	Name  string  // The "collapsed" counter: <chart>:{<bucket1>,<bucket2>,...}
 	Rate  float64 // If X <= Rate, report this counter
 	Depth int     `json:",omitempty"` // for stack counters
+	// Epsilon, if positive, is the privacy parameter of the randomized
+	// response with which each bucket of the counter is reported.
+	Epsilon float64 `json:",omitempty"`
 }
 
 // A Report is the weekly aggregate of counters.
@@ -38,6 +41,9 @@ type Report struct {
 	X        float64 // A random probability used to determine which counters are uploaded
 	Programs []*ProgramReport
 	Config   string // version of UploadConfig used
+	// Partial is the fraction of a week covered by a report that was
+	// flushed before its count files expired, or 0 for a full report.
+	Partial float64 `json:",omitempty"`
 }
 
 type ProgramReport struct {
@@ -48,4 +54,9 @@ type ProgramReport struct {
 	GOARCH    string
 	Counters  map[string]int64
 	Stacks    map[string]int64
+	// Noised lists the charts whose buckets are reported in Counters with
+	// noise, as 0 or 1, using randomized response (see
+	// [CounterConfig.Epsilon]). Reports from uploaders that predate noise
+	// never have any.
+	Noised []string `json:",omitempty"`
 }
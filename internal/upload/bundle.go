@@ -0,0 +1,440 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+// This file defines report bundles, which carry the reports of machines
+// that cannot reach the upload server to machines that can.
+//
+// A bundle is a gzipped tar file holding a manifest, manifest.json, and
+// the reports it lists: reports/DATE.json for the upload endpoint, and
+// reports/NAME/DATE.json for the destination NAME.
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	bundleVersion       = 1
+	bundleManifest      = "manifest.json"
+	bundleReportDir     = "reports/"
+	maxBundleReports    = 1000
+	maxBundleReportSize = 1 << 20
+)
+
+// reportNameRE matches the names of the reports in a bundle.
+var reportNameRE = regexp.MustCompile(`^\d\d\d\d-\d\d-\d\d\.json$`)
+
+// A Manifest describes the contents of a bundle.
+type Manifest struct {
+	Version int
+	Created time.Time
+	Reports []BundleReport
+}
+
+// A BundleReport describes a report in a bundle.
+type BundleReport struct {
+	Destination string // name of the destination, or "" for the upload endpoint
+	Name        string // e.g. "2024-01-07.json"
+	Size        int64
+	SHA256      string // hex-encoded checksum of the report
+}
+
+// path returns the path of the report in the bundle.
+func (r BundleReport) path() string {
+	if r.Destination == "" {
+		return bundleReportDir + r.Name
+	}
+	return bundleReportDir + r.Destination + "/" + r.Name
+}
+
+// parseReportPath parses the path of a report in a bundle, reporting
+// whether it is valid.
+func parseReportPath(path string) (dest, name string, ok bool) {
+	rest, ok := strings.CutPrefix(path, bundleReportDir)
+	if !ok {
+		return "", "", false
+	}
+	if dest, name, ok = strings.Cut(rest, "/"); !ok {
+		dest, name = "", rest
+	} else if !destNameRE.MatchString(dest) {
+		return "", "", false
+	}
+	return dest, name, reportNameRE.MatchString(name)
+}
+
+// isReportPath reports whether path is a valid report path in a bundle.
+func isReportPath(path string) bool {
+	_, _, ok := parseReportPath(path)
+	return ok
+}
+
+// findDest returns the destination of u with the given name, or nil.
+func (u *uploader) findDest(name string) *destination {
+	for _, d := range u.dests {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// Export writes the reports of the telemetry directory that are ready for
+// upload, to the upload endpoint and to the configured destinations, into
+// a new bundle file, and marks them as exported, so that they are never
+// uploaded from this machine. Reports are ready for upload only if
+// telemetry uploading is on. Export returns the paths of the exported
+// reports in the bundle; if there are none, it does not create the bundle.
+//
+// Export first builds the reports of the count files that have expired,
+// as [Run] does, but without network access: the upload config of the
+// upload endpoint is [RunConfig.Config] if set, or else the cached config,
+// if it is recent enough. Without either, only the reports that already
+// exist are exported.
+func Export(rcfg RunConfig, bundle string) ([]string, error) {
+	ctx := context.Background()
+	if rcfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rcfg.Timeout)
+		defer cancel()
+	}
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+	if err := u.dir.Migrate(); err != nil {
+		return nil, fmt.Errorf("telemetry directory is not writable: %v", err)
+	}
+
+	unlock, err := u.lock()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer unlock()
+
+	todo := u.findWork()
+	if mode, _ := u.dir.Mode(); UploadsEnabled(mode) && u.offlineConfigs(ctx) {
+		if err := u.reports(ctx, &todo); err != nil {
+			return nil, fmt.Errorf("reports failed: %w", err)
+		}
+	}
+	var (
+		manifest = Manifest{Version: bundleVersion, Created: u.startTime}
+		files    []string
+		dests    []*destination
+		contents [][]byte
+	)
+	for i, d := range u.dests {
+		dw := todo.dests[i]
+		for _, fname := range dw.readyfiles {
+			name := filepath.Base(fname)
+			if dw.uploaded[name] || !reportNameRE.MatchString(name) {
+				continue
+			}
+			data, err := os.ReadFile(fname)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(data)
+			manifest.Reports = append(manifest.Reports, BundleReport{
+				Destination: d.name,
+				Name:        name,
+				Size:        int64(len(data)),
+				SHA256:      hex.EncodeToString(sum[:]),
+			})
+			files = append(files, fname)
+			dests = append(dests, d)
+			contents = append(contents, data)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	if err := writeBundle(bundle, &manifest, contents); err != nil {
+		return nil, err
+	}
+
+	// Record the exported reports as uploaded.
+	var paths []string
+	for i, fname := range files {
+		if err := os.WriteFile(filepath.Join(dests[i].uploadDir, filepath.Base(fname)), contents[i], 0644); err != nil {
+			return paths, err
+		}
+		os.Remove(fname)
+		path := manifest.Reports[i].path()
+		u.logger.Printf("Exported %s to %s", path, bundle)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// offlineConfigs sets the upload configs of the destinations without
+// network access, and reports whether the config of the upload endpoint is
+// available. If it is not, no reports must be built, as the counters of
+// the upload endpoint would be lost.
+func (u *uploader) offlineConfigs(ctx context.Context) bool {
+	for _, d := range u.dests {
+		config, version, err := d.offlineConfig(ctx)
+		if err != nil {
+			if d.name == "" {
+				u.logger.Printf("No upload config available offline, not building reports: %v", err)
+				return false
+			}
+			u.logger.Printf("Failed to fetch upload config of destination %s: %v", d.name, err)
+			d.config = nil
+			continue
+		}
+		d.config, d.configVersion = config, version
+	}
+	return true
+}
+
+// writeBundle writes a bundle file with the given manifest and report
+// contents.
+func writeBundle(bundle string, manifest *Manifest, contents [][]byte) error {
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: manifest.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(bundleManifest, data); err != nil {
+		return err
+	}
+	for i, r := range manifest.Reports {
+		if err := add(r.path(), contents[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// Write the bundle atomically, so that reports are marked as
+	// exported only if the bundle is complete.
+	tmp, err := os.CreateTemp(filepath.Dir(bundle), ".bundle-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(buf.Bytes())
+	if err := tmp.Close(); werr == nil {
+		werr = err
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), bundle)
+	}
+	if werr != nil {
+		os.Remove(tmp.Name())
+	}
+	return werr
+}
+
+// ReadBundle reads a bundle, checking that it holds exactly the reports
+// listed in its manifest, with the listed checksums. It returns the
+// manifest, and the contents of each report, in the order of the manifest.
+func ReadBundle(r io.Reader) (*Manifest, [][]byte, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	tr := tar.NewReader(zr)
+	var (
+		manifest *Manifest
+		files    = make(map[string][]byte)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("invalid bundle: %s is not a regular file", hdr.Name)
+		}
+		if hdr.Size > maxBundleReportSize {
+			return nil, nil, fmt.Errorf("invalid bundle: %s is too large", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid bundle: %v", err)
+		}
+		switch name := hdr.Name; {
+		case name == bundleManifest:
+			if manifest != nil {
+				return nil, nil, fmt.Errorf("invalid bundle: duplicate manifest")
+			}
+			manifest = new(Manifest)
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid bundle manifest: %v", err)
+			}
+		case isReportPath(name):
+			if _, ok := files[name]; ok {
+				return nil, nil, fmt.Errorf("invalid bundle: duplicate report %s", name)
+			}
+			if len(files) == maxBundleReports {
+				return nil, nil, fmt.Errorf("invalid bundle: more than %d reports", maxBundleReports)
+			}
+			files[name] = data
+		default:
+			return nil, nil, fmt.Errorf("invalid bundle: unexpected file %s", name)
+		}
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("invalid bundle: no manifest")
+	}
+	if manifest.Version != bundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	if len(manifest.Reports) != len(files) {
+		return nil, nil, fmt.Errorf("invalid bundle: manifest lists %d reports, bundle has %d", len(manifest.Reports), len(files))
+	}
+	var contents [][]byte
+	for _, r := range manifest.Reports {
+		path := r.path()
+		if dest, name, ok := parseReportPath(path); !ok || dest != r.Destination || name != r.Name {
+			return nil, nil, fmt.Errorf("invalid bundle: invalid report %s", path)
+		}
+		data, ok := files[path]
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid bundle: missing report %s", path)
+		}
+		delete(files, path) // a report listed twice is missing the second time
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != r.Size || hex.EncodeToString(sum[:]) != r.SHA256 {
+			return nil, nil, fmt.Errorf("invalid bundle: checksum mismatch for report %s", path)
+		}
+		if !json.Valid(data) {
+			return nil, nil, fmt.Errorf("invalid bundle: report %s is not valid JSON", path)
+		}
+		contents = append(contents, data)
+	}
+	return manifest, contents, nil
+}
+
+// An ImportResult is the outcome of importing a report of a bundle.
+type ImportResult struct {
+	Name    string // path of the report in the bundle
+	Skipped bool   // the report was uploaded by an earlier import
+	NoDest  bool   // the report is for a destination Import was not given, and was not uploaded
+	Err     error  // if non-nil, the upload failed
+}
+
+// Import checks the given bundle, and uploads each of its reports as Run
+// does, to the upload endpoint or to the configured destination it was
+// created for. Reports are recorded under the imported subdirectory of the
+// destination's upload directory, by checksum, so that importing a bundle
+// again does not upload its reports twice. Reports for a destination that
+// is not in [RunConfig.Destinations] are skipped, and marked NoDest in the
+// results.
+//
+// Import returns an error if the bundle is invalid, in which case nothing
+// is uploaded; the outcome of each upload is in the results.
+func Import(rcfg RunConfig, bundle string) ([]ImportResult, error) {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest, contents, err := ReadBundle(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if rcfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rcfg.Timeout)
+		defer cancel()
+	}
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+	if err := os.MkdirAll(u.dir.LocalDir(), 0777); err != nil {
+		return nil, err
+	}
+	unlock, err := u.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var results []ImportResult
+	for i, r := range manifest.Reports {
+		res := ImportResult{Name: r.path()}
+		if u.findDest(r.Destination) == nil {
+			u.logger.Printf("Skipping %s: unknown destination %q", r.path(), r.Destination)
+			res.NoDest = true
+		} else {
+			res.Skipped, res.Err = u.importReport(ctx, r, contents[i])
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// importReport uploads the report r of a bundle, with the given contents,
+// unless it was uploaded by an earlier import, in which case it reports
+// that it skipped the report.
+func (u *uploader) importReport(ctx context.Context, r BundleReport, contents []byte) (skipped bool, _ error) {
+	d := u.findDest(r.Destination)
+	importDir := filepath.Join(d.uploadDir, "imported")
+	if err := os.MkdirAll(importDir, 0777); err != nil {
+		return false, err
+	}
+	date := strings.TrimSuffix(r.Name, ".json")
+	done := filepath.Join(importDir, date+"."+r.SHA256[:16]+".json")
+	if _, err := os.Stat(done); err == nil {
+		u.logger.Printf("Already uploaded: %s", r.path())
+		return true, nil
+	}
+	if err := u.uploadWithRetries(ctx, d, r.path(), date, contents); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(done, contents, 0644); err != nil {
+		u.logger.Printf("Error recording upload of %s: %v", r.path(), err)
+	}
+	u.logger.Printf("Uploaded %s to %v", r.path(), d)
+	return false, nil
+}
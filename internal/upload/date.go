@@ -37,23 +37,7 @@ func (u *uploader) counterDateSpan(fname string) (begin, end time.Time, _ error)
 	if err != nil {
 		return time.Time{}, time.Time{}, err
 	}
-	timeBegin, ok := parsed.Meta["TimeBegin"]
-	if !ok {
-		return time.Time{}, time.Time{}, fmt.Errorf("missing counter metadata for TimeBegin")
-	}
-	begin, err = time.Parse(time.RFC3339, timeBegin)
-	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse TimeBegin: %v", err)
-	}
-	timeEnd, ok := parsed.Meta["TimeEnd"]
-	if !ok {
-		return time.Time{}, time.Time{}, fmt.Errorf("missing counter metadata for TimeEnd")
-	}
-	end, err = time.Parse(time.RFC3339, timeEnd)
-	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse TimeEnd: %v", err)
-	}
-	return begin, end, nil
+	return metaSpan(parsed.Meta)
 }
 
 // avoid parsing count files multiple times
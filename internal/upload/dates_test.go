@@ -6,6 +6,8 @@ package upload
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -31,7 +33,17 @@ import (
 func CreateTestUploadServer(t *testing.T) (*httptest.Server, func() [][]byte) {
 	s := &uploadQueue{}
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		buf, err := io.ReadAll(r.Body)
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip request received: %v", err)
+				http.Error(w, "gzip failed", http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		buf, err := io.ReadAll(body)
 		if err != nil {
 			t.Errorf("invalid request received: %v", err)
 			http.Error(w, "read failed", http.StatusBadRequest)
@@ -204,7 +216,7 @@ func TestDates(t *testing.T) {
 			if err := os.MkdirAll(dbg, 0777); err != nil {
 				t.Fatal(err)
 			}
-			uploader, err := newUploader(RunConfig{
+			uploader, err := newUploader(context.Background(), RunConfig{
 				TelemetryDir: telemetryDir,
 				UploadURL:    srv.URL,
 				Env:          env,
@@ -371,7 +383,7 @@ func doTest(t *testing.T, u *uploader, test *Test, known *countFileInfo) int {
 	}
 
 	// run
-	u.Run()
+	u.Run(context.Background())
 
 	// check results
 	var cfiles, rfiles, lfiles, ufiles int
@@ -385,6 +397,7 @@ func doTest(t *testing.T, u *uploader, test *Test, known *countFileInfo) int {
 		case strings.HasSuffix(f.Name(), ".v1.count"):
 			cfiles++
 		case f.Name() == "weekends": // ok
+		case f.Name() == "upload.lock": // ok
 		case strings.HasPrefix(f.Name(), "local."):
 			lfiles++
 		case strings.HasSuffix(f.Name(), ".json"):
@@ -408,7 +421,11 @@ func doTest(t *testing.T, u *uploader, test *Test, known *countFileInfo) int {
 		t.Errorf("%v reading uploaddir %s", err, u.dir.UploadDir())
 		return 0
 	}
-	ufiles = len(fis) // assume there's nothing but .json reports
+	for _, f := range fis {
+		if reportNameRE.MatchString(f.Name()) { // not status.json
+			ufiles++
+		}
+	}
 	if test.wantCounts != cfiles {
 		t.Errorf("%s: got %d countfiles, wanted %d", test.name, cfiles, test.wantCounts)
 	}
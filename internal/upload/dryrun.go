@@ -0,0 +1,124 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// A DryRunReport is a report that a dry run found would be uploaded.
+type DryRunReport struct {
+	Destination string // name of the destination, or "" for the upload endpoint
+	Week        string // the report's week, as YYYY-MM-DD
+
+	// Ready reports whether the report was created by an earlier run, and
+	// is waiting to be uploaded. Otherwise, the dry run built it from the
+	// count files of Week.
+	Ready bool
+
+	// Report is the report that would be uploaded, or nil if none would
+	// be, in which case Counters says why.
+	Report *telemetry.Report
+
+	// Counters holds the decisions for each counter of the local report,
+	// if the dry run built the report.
+	Counters []CounterDecision
+}
+
+// runDry is the dry run counterpart of [uploader.Run]: it builds the
+// reports that would be uploaded, and passes them to u.dryRun, without
+// modifying the telemetry directory.
+func (u *uploader) runDry(ctx context.Context) error {
+	// The reports are built as if uploads were enabled. The as-of date
+	// of the mode is only meaningful if they are.
+	mode, asof := u.dir.Mode()
+	if !UploadsEnabled(mode) {
+		u.logger.Printf("Dry run: mode is %s, building reports as if it were on", mode)
+		asof = time.Time{}
+	}
+	todo := u.findWork()
+
+	for i, d := range u.dests {
+		for _, fname := range todo.dests[i].readyfiles {
+			if todo.dests[i].uploaded[filepath.Base(fname)] {
+				continue // the report would be removed
+			}
+			data, err := os.ReadFile(fname)
+			if err != nil {
+				return err
+			}
+			report := new(telemetry.Report)
+			if err := json.Unmarshal(data, report); err != nil {
+				u.logger.Printf("Dry run: invalid report %s: %v", fname, err)
+				continue
+			}
+			u.dryRun(&DryRunReport{
+				Destination: d.name,
+				Week:        strings.TrimSuffix(filepath.Base(fname), ".json"),
+				Ready:       true,
+				Report:      report,
+			})
+		}
+	}
+
+	lastWeeks := u.lastWeeks(&todo)
+	countFiles, earliest := u.groupCountFiles(todo.countfiles)
+	var expiries []string
+	for expiry := range countFiles {
+		expiries = append(expiries, expiry)
+	}
+	sort.Strings(expiries)
+	for _, expiry := range expiries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if notNeeded(expiry, todo) {
+			u.logger.Printf("Dry run: files for %s not needed", expiry)
+			continue
+		}
+		reports, err := u.buildReports(earliest[expiry], expiry, countFiles[expiry], lastWeeks, "on", asof)
+		if err != nil {
+			u.logger.Printf("Dry run: failed to build report for %s: %v", expiry, err)
+			continue
+		}
+		for i, d := range u.dests {
+			if reportExists(expiry, todo.dests[i]) {
+				continue
+			}
+			u.dryRun(&DryRunReport{
+				Destination: d.name,
+				Week:        expiry,
+				Report:      reports.uploads[i],
+				Counters:    reports.decisions[i],
+			})
+		}
+	}
+	return nil
+}
+
+// String returns a short description of the decision, for display.
+func (c CounterDecision) String() string {
+	name, _, stack := strings.Cut(c.Counter, "\n")
+	if stack {
+		name += " (stack)"
+	}
+	verdict := "kept"
+	if c.Noised {
+		verdict = "kept with noise"
+	}
+	if !c.Kept {
+		verdict = "dropped: " + c.Reason
+	}
+	return fmt.Sprintf("%s@%s %s %s/%s %s: %s", c.Program, c.Version, c.GoVersion, c.GOOS, c.GOARCH, name, verdict)
+}
@@ -8,23 +8,38 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // files to handle
 type work struct {
 	// absolute file names
 	countfiles []string // count files to process
+	// reports of each destination, in the order of uploader.dests
+	dests []destWork
+}
+
+// UploadsEnabled reports whether reports are uploaded in the given mode.
+// In review mode, reports are uploaded once they are approved.
+func UploadsEnabled(mode string) bool {
+	return mode == "on" || mode == "review"
+}
+
+// reports of a destination
+type destWork struct {
+	// absolute file names
 	readyfiles []string // old reports to upload
 	// relative names
 	uploaded map[string]bool // reports that have been uploaded
+	reviewed map[string]bool // reports pending or rejected in review
 }
 
 // find all the files that look like counter files or reports
 // that need to be uploaded. (There may be unexpected leftover files
 // and uploading is supposed to be idempotent.)
 func (u *uploader) findWork() work {
-	localdir, uploaddir := u.dir.LocalDir(), u.dir.UploadDir()
-	var ans work
+	localdir := u.dir.LocalDir()
+	ans := work{dests: make([]destWork, len(u.dests))}
 	fis, err := os.ReadDir(localdir)
 	if err != nil {
 		u.logger.Printf("Could not find work: failed to read local dir %s: %v", localdir, err)
@@ -35,8 +50,6 @@ func (u *uploader) findWork() work {
 	u.logger.Printf("Finding work: mode %s asof %s", mode, asof)
 
 	// count files end in .v1.count
-	// reports end in .json. If they are not to be uploaded they
-	// start with local.
 	for _, fi := range fis {
 		if strings.HasSuffix(fi.Name(), ".v1.count") {
 			fname := filepath.Join(localdir, fi.Name())
@@ -50,10 +63,27 @@ func (u *uploader) findWork() work {
 				u.logger.Printf("Collecting count file %s", fi.Name())
 				ans.countfiles = append(ans.countfiles, fname)
 			}
-		} else if strings.HasPrefix(fi.Name(), "local.") {
+		}
+	}
+	for i, d := range u.dests {
+		ans.dests[i] = u.findReports(d, mode, asof)
+	}
+	return ans
+}
+
+// findReports finds the reports of the destination d that are ready for
+// upload, and those that have been uploaded.
+func (u *uploader) findReports(d *destination, mode string, asof time.Time) destWork {
+	var ans destWork
+	// reports end in .json. If they are not to be uploaded they
+	// start with local.
+	fis, _ := os.ReadDir(d.localDir) // a destination's directory may not exist yet
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), "local.") {
 			// skip
-		} else if strings.HasSuffix(fi.Name(), ".json") && mode == "on" {
-			// Collect reports that are ready for upload.
+		} else if strings.HasSuffix(fi.Name(), ".json") && UploadsEnabled(mode) {
+			// Collect reports that are ready for upload. In review mode,
+			// these are the approved reports.
 			reportDate := u.uploadReportDate(fi.Name())
 			if !asof.IsZero() && !reportDate.IsZero() {
 				// If both the mode asof date and the report date are present, do the
@@ -69,7 +99,7 @@ func (u *uploader) findWork() work {
 					// TODO(rfindley): store the begin date in reports, so that we can
 					// verify this assumption.
 					u.logger.Printf("Uploadable: %s", fi.Name())
-					ans.readyfiles = append(ans.readyfiles, filepath.Join(localdir, fi.Name()))
+					ans.readyfiles = append(ans.readyfiles, filepath.Join(d.localDir, fi.Name()))
 				}
 			} else {
 				// ...otherwise fall back on the old behavior of uploading all
@@ -79,21 +109,35 @@ func (u *uploader) findWork() work {
 				// should only upload if we know both the asof date and the report
 				// date, and they are acceptable.
 				u.logger.Printf("Uploadable (missing date): %s", fi.Name())
-				ans.readyfiles = append(ans.readyfiles, filepath.Join(localdir, fi.Name()))
+				ans.readyfiles = append(ans.readyfiles, filepath.Join(d.localDir, fi.Name()))
 			}
 		}
 	}
 
-	fis, err = os.ReadDir(uploaddir)
+	for _, dir := range []string{d.pendingDir(), d.rejectedDir()} {
+		fis, _ := os.ReadDir(dir)
+		for _, fi := range fis {
+			if strings.HasSuffix(fi.Name(), ".json") {
+				if ans.reviewed == nil {
+					ans.reviewed = make(map[string]bool)
+				}
+				ans.reviewed[fi.Name()] = true
+			}
+		}
+	}
+
+	fis, err := os.ReadDir(d.uploadDir)
 	if err != nil {
-		os.MkdirAll(uploaddir, 0777)
+		if u.dryRun == nil {
+			os.MkdirAll(d.uploadDir, 0777)
+		}
 		return ans
 	}
 	// There should be only one of these per day; maybe sometime
 	// we'll want to clean the directory.
 	ans.uploaded = make(map[string]bool)
 	for _, fi := range fis {
-		if strings.HasSuffix(fi.Name(), ".json") {
+		if reportNameRE.MatchString(fi.Name()) { // not status.json
 			u.logger.Printf("Already uploaded: %s", fi.Name())
 			ans.uploaded[fi.Name()] = true
 		}
@@ -5,10 +5,19 @@
 package upload
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/telemetry/internal/config"
+	"golang.org/x/telemetry/internal/telemetry"
 )
 
 // make sure we can talk to the test server
@@ -54,3 +63,85 @@ func TestRandom(t *testing.T) {
 		t.Errorf("cnt %d more than 7 sigma(10) from mean(100)", cnt)
 	}
 }
+
+// check that counters with an epsilon are reported with randomized response
+func TestNoisyCounters(t *testing.T) {
+	const epsilon = 1.0
+	cfg := &telemetry.UploadConfig{
+		GoVersion: []string{"go1.22"},
+		Programs: []*telemetry.ProgramConfig{{
+			Name:     "prog",
+			Versions: []string{"v1.0.0"},
+			Counters: []telemetry.CounterConfig{
+				{Name: "plain", Rate: 1},
+				{Name: "chart:{a,b,c}", Rate: 1, Epsilon: epsilon},
+			},
+		}},
+	}
+	report := &telemetry.Report{
+		Week: "2024-01-08",
+		Programs: []*telemetry.ProgramReport{{
+			Program:   "prog",
+			Version:   "v1.0.0",
+			GoVersion: "go1.22",
+			Counters:  map[string]int64{"plain": 7, "chart:a": 5},
+		}},
+	}
+
+	// cnt has a binomial distribution for each bucket; reject at 7 sigma.
+	const N = 10000
+	cnt := make(map[string]int)
+	for i := 0; i < N; i++ {
+		upload, decisions := uploadReport(report, cfg, "v1", 0.5, "")
+		counters := upload.Programs[0].Counters
+		if counters["plain"] != 7 || len(counters) != 4 {
+			t.Fatalf("got counters %v, want plain:7 and the three chart buckets", counters)
+		}
+		if noised := upload.Programs[0].Noised; !slices.Equal(noised, []string{"chart"}) {
+			t.Fatalf("got noised charts %q, want [chart]", noised)
+		}
+		for _, b := range []string{"chart:a", "chart:b", "chart:c"} {
+			switch v := counters[b]; v {
+			case 0:
+			case 1:
+				cnt[b]++
+			default:
+				t.Fatalf("got %s:%d, want 0 or 1", b, v)
+			}
+		}
+		if i == 0 {
+			for _, d := range decisions {
+				if !d.Kept || d.Noised != (d.Counter == "chart:a") {
+					t.Errorf("got decision %+v for %s", d, d.Counter)
+				}
+			}
+		}
+	}
+	p := config.TruthProbability(epsilon)
+	sigma := math.Sqrt(N * p * (1 - p))
+	for b, want := range map[string]float64{"chart:a": N * p, "chart:b": N * (1 - p), "chart:c": N * (1 - p)} {
+		if got := float64(cnt[b]); math.Abs(got-want) > 7*sigma {
+			t.Errorf("%s reported %v times out of %d, want about %v", b, got, N, want)
+		}
+	}
+}
+
+func TestUploadOutcome(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{nil, outcomeOK},
+		{fmt.Errorf("%w: %w", ErrRejected, &statusError{400, "400 Bad Request"}), outcomeHTTP4xx},
+		{fmt.Errorf("server returned %w", &statusError{404, "404 Not Found"}), outcomeHTTP4xx},
+		{&RetryAfterError{time.Second, fmt.Errorf("server returned %w", &statusError{503, "503 Service Unavailable"})}, outcomeHTTP5xx},
+		{ErrRejected, outcomeHTTP4xx},
+		{errors.New("connection refused"), outcomeNetErr},
+		{context.DeadlineExceeded, outcomeNetErr},
+		{context.Canceled, outcomeSkipped},
+	} {
+		if got := uploadOutcome(test.err); got != test.want {
+			t.Errorf("uploadOutcome(%v) = %s, want %s", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+// This file defines the flushing of count files. Count files are only
+// collected once they expire, so machines that live for less than a week,
+// such as CI runners, would never report their counters. Flushing makes
+// the active count files expire at the start of the upload, so that their
+// counters are reported in a partial report.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/telemetry/internal/counter"
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// flush makes the active count files expire now, by rewriting their
+// TimeEnd (see [counter.Expire]). Count files that are in use by running
+// processes are left alone, since the processes would keep incrementing
+// them after they were reported: they are reported when they expire, or by
+// a flush after the processes have exited.
+//
+// Reports are named after their week, so only one report can be flushed
+// each day: if there is already a report for today, flush does nothing.
+func (u *uploader) flush() {
+	end := flushTime(u.startTime)
+	date := end.Format(telemetry.DateOnly)
+	localdir := u.dir.LocalDir()
+	if _, err := os.Stat(filepath.Join(localdir, "local."+date+".json")); err == nil {
+		u.logger.Printf("Not flushing: there is already a report for %s", date)
+		return
+	}
+	fis, err := os.ReadDir(localdir)
+	if err != nil {
+		u.logger.Printf("Not flushing: failed to read local dir %s: %v", localdir, err)
+		return
+	}
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".v1.count") {
+			continue
+		}
+		fname := filepath.Join(localdir, fi.Name())
+		// Don't use the parsed count file cache: the file is about to change.
+		begin, expiry, err := countFileSpan(fname)
+		switch {
+		case err != nil:
+			u.logger.Printf("Not flushing count file %s: %v", fi.Name(), err)
+		case !expiry.After(u.startTime):
+			// already inactive
+		case !end.After(begin):
+			u.logger.Printf("Not flushing count file %s: it begins at %s", fi.Name(), begin)
+		default:
+			newName, err := counter.Expire(fname, end)
+			if err == counter.ErrInUse {
+				u.logger.Printf("Not flushing count file %s: it is in use", fi.Name())
+				continue
+			}
+			if err != nil {
+				u.logger.Printf("Failed to flush count file %s: %v", fi.Name(), err)
+				continue
+			}
+			u.logger.Printf("Flushed count file %s as %s", fi.Name(), filepath.Base(newName))
+		}
+	}
+}
+
+// flushTime returns the TimeEnd of the count files flushed at start. It is
+// before start, so that the files are collected, and at a whole second,
+// but not at midnight, so that it is told apart from a regular TimeEnd.
+func flushTime(start time.Time) time.Time {
+	end := start.UTC().Add(-time.Second).Truncate(time.Second)
+	if isMidnight(end) {
+		end = end.Add(-time.Second)
+	}
+	return end
+}
+
+// isMidnight reports whether t is at midnight UTC, as the TimeEnd of count
+// files is unless they were flushed.
+func isMidnight(t time.Time) bool {
+	return t.Equal(t.UTC().Truncate(24 * time.Hour))
+}
+
+// countFileSpan returns the (begin, end) span recorded in the metadata of
+// the count file fname.
+func countFileSpan(fname string) (begin, end time.Time, _ error) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	f, err := counter.Parse(fname, buf)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return metaSpan(f.Meta)
+}
+
+// metaSpan returns the (begin, end) span recorded in the metadata of a
+// count file.
+func metaSpan(meta map[string]string) (begin, end time.Time, _ error) {
+	timeBegin, ok := meta["TimeBegin"]
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("missing counter metadata for TimeBegin")
+	}
+	begin, err := time.Parse(time.RFC3339, timeBegin)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse TimeBegin: %v", err)
+	}
+	timeEnd, ok := meta["TimeEnd"]
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("missing counter metadata for TimeEnd")
+	}
+	end, err = time.Parse(time.RFC3339, timeEnd)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to parse TimeEnd: %v", err)
+	}
+	return begin, end, nil
+}
+
+// partialWeek returns the fraction of a week covered by a report of count
+// files spanning from begin to end, if all of them were flushed, or 0.
+func partialWeek(begin, end time.Time, flushed bool) float64 {
+	const week = 7 * 24 * time.Hour
+	if !flushed || end.Sub(begin) >= week {
+		return 0
+	}
+	return max(end.Sub(begin).Seconds(), 1) / week.Seconds()
+}
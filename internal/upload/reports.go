@@ -5,6 +5,7 @@
 package upload
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,38 +22,19 @@ import (
 	"golang.org/x/telemetry/internal/telemetry"
 )
 
-// reports generates reports from inactive count files
-func (u *uploader) reports(todo *work) ([]string, error) {
+// reports generates reports from inactive count files, adding them to the
+// ready files of each destination, and stopping with ctx's error if ctx is
+// done.
+func (u *uploader) reports(ctx context.Context, todo *work) error {
 	if mode, _ := u.dir.Mode(); mode == "off" {
-		return nil, nil // no reports
-	}
-	thisInstant := u.startTime
-	today := thisInstant.Format(telemetry.DateOnly)
-	lastWeek := latestReport(todo.uploaded)
-	if lastWeek >= today { //should never happen
-		lastWeek = ""
-	}
-	u.logger.Printf("Last week: %s, today: %s", lastWeek, today)
-	countFiles := make(map[string][]string) // expiry date string->filenames
-	earliest := make(map[string]time.Time)  // earliest begin time for any counter
-	for _, f := range todo.countfiles {
-		begin, end, err := u.counterDateSpan(f)
-		if err != nil {
-			// This shouldn't happen: we should have already skipped count files that
-			// don't contain valid start or end times.
-			u.logger.Printf("BUG: failed to parse expiry for collected count file: %v", err)
-			continue
-		}
-
-		if end.Before(thisInstant) {
-			expiry := end.Format(dateFormat)
-			countFiles[expiry] = append(countFiles[expiry], f)
-			if earliest[expiry].IsZero() || earliest[expiry].After(begin) {
-				earliest[expiry] = begin
-			}
-		}
+		return nil // no reports
 	}
+	lastWeeks := u.lastWeeks(todo)
+	countFiles, earliest := u.groupCountFiles(todo.countfiles)
 	for expiry, files := range countFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if notNeeded(expiry, *todo) {
 			u.logger.Printf("Files for %s not needed, deleting %v", expiry, files)
 			// The report already exists.
@@ -59,17 +42,60 @@ func (u *uploader) reports(todo *work) ([]string, error) {
 			u.deleteFiles(files)
 			continue
 		}
-		fname, err := u.createReport(earliest[expiry], expiry, files, lastWeek)
+		fnames, err := u.createReport(earliest[expiry], expiry, files, lastWeeks)
 		if err != nil {
 			u.logger.Printf("Failed to create report for %s: %v", expiry, err)
 			continue
 		}
-		if fname != "" {
-			u.logger.Printf("Ready to upload: %s", filepath.Base(fname))
-			todo.readyfiles = append(todo.readyfiles, fname)
+		for i, fname := range fnames {
+			if fname != "" {
+				u.logger.Printf("Ready to upload to %v: %s", u.dests[i], filepath.Base(fname))
+				todo.dests[i].readyfiles = append(todo.dests[i].readyfiles, fname)
+			}
+		}
+	}
+	return nil
+}
+
+// lastWeeks returns the last week of each destination: each destination
+// tracks its own last week.
+func (u *uploader) lastWeeks(todo *work) []string {
+	today := u.startTime.Format(telemetry.DateOnly)
+	lastWeeks := make([]string, len(u.dests))
+	for i, d := range u.dests {
+		lastWeek := latestReport(todo.dests[i].uploaded)
+		if lastWeek >= today { //should never happen
+			lastWeek = ""
+		}
+		lastWeeks[i] = lastWeek
+		u.logger.Printf("Last week of %v: %s, today: %s", d, lastWeek, today)
+	}
+	return lastWeeks
+}
+
+// groupCountFiles groups the inactive count files by expiry date, and
+// returns the earliest begin time of the count files of each date.
+func (u *uploader) groupCountFiles(files []string) (countFiles map[string][]string, earliest map[string]time.Time) {
+	countFiles = make(map[string][]string) // expiry date string->filenames
+	earliest = make(map[string]time.Time)  // earliest begin time for any counter
+	for _, f := range files {
+		begin, end, err := u.counterDateSpan(f)
+		if err != nil {
+			// This shouldn't happen: we should have already skipped count files that
+			// don't contain valid start or end times.
+			u.logger.Printf("BUG: failed to parse expiry for collected count file: %v", err)
+			continue
+		}
+
+		if end.Before(u.startTime) {
+			expiry := end.Format(dateFormat)
+			countFiles[expiry] = append(countFiles[expiry], f)
+			if earliest[expiry].IsZero() || earliest[expiry].After(begin) {
+				earliest[expiry] = begin
+			}
 		}
 	}
-	return todo.readyfiles, nil
+	return countFiles, earliest
 }
 
 // latestReport returns the YYYY-MM-DD of the last report uploaded
@@ -91,12 +117,24 @@ func latestReport(uploaded map[string]bool) string {
 }
 
 // notNeeded returns true if the report for date has already been created
+// for every destination
 func notNeeded(date string, todo work) bool {
-	if todo.uploaded != nil && todo.uploaded[date+".json"] {
+	for _, dw := range todo.dests {
+		if !reportExists(date, dw) {
+			return false
+		}
+	}
+	return len(todo.dests) > 0
+}
+
+// reportExists returns true if the report for date has already been created
+// for a destination
+func reportExists(date string, dw destWork) bool {
+	if dw.uploaded[date+".json"] || dw.reviewed[date+".json"] {
 		return true
 	}
-	// maybe the report is already in todo.readyfiles
-	for _, f := range todo.readyfiles {
+	// maybe the report is already in readyfiles
+	for _, f := range dw.readyfiles {
 		if strings.Contains(f, date) {
 			return true
 		}
@@ -117,38 +155,155 @@ func (u *uploader) deleteFiles(files []string) {
 
 // createReport creates local and upload report files by
 // combining all the count files for the expiryDate, and
-// returns the upload report file's path.
+// returns the paths of the upload report files of each
+// destination, or "" for destinations that get no report.
+// lastWeeks holds the last week of each destination.
 // It may delete the count files once local and upload report
 // files are successfully created.
-func (u *uploader) createReport(start time.Time, expiryDate string, countFiles []string, lastWeek string) (string, error) {
-	uploadOK := true
+func (u *uploader) createReport(start time.Time, expiryDate string, countFiles []string, lastWeeks []string) ([]string, error) {
 	mode, asof := u.dir.Mode()
-	if mode != "on" {
+	reports, err := u.buildReports(start, expiryDate, countFiles, lastWeeks, mode, asof)
+	if err != nil {
+		return nil, err
+	}
+	// 1. generate the local report
+	localContents, err := json.MarshalIndent(reports.local, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal report for %s: %v", expiryDate, err)
+	}
+	// check that the report can be read back
+	// TODO(pjw): remove for production?
+	var report2 telemetry.Report
+	if err := json.Unmarshal(localContents, &report2); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal local report for %s: %v", expiryDate, err)
+	}
+
+	// 2. marshal the uploadable version for each destination
+	uploadContents := make([][]byte, len(u.dests))
+	for i, upload := range reports.uploads {
+		if upload == nil {
+			continue
+		}
+		uploadContents[i], err = json.MarshalIndent(upload, "", " ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal upload report for %s: %v", expiryDate, err)
+		}
+	}
+	// In review mode, the upload reports are held for review instead of
+	// being ready for upload.
+	localFileName := filepath.Join(u.dir.LocalDir(), "local."+expiryDate+".json")
+	uploadFileNames := make([]string, len(u.dests))
+	for i, d := range u.dests {
+		if uploadContents[i] != nil {
+			dir := d.localDir
+			if mode == "review" {
+				dir = d.pendingDir()
+			}
+			uploadFileNames[i] = filepath.Join(dir, expiryDate+".json")
+		}
+	}
+
+	/* Prepare to write files */
+	// if any file exists, someone has been here ahead of us
+	// (there is still a race, but this check shortens the open window)
+	if _, err := os.Stat(localFileName); err == nil {
+		u.deleteFiles(countFiles)
+		return nil, fmt.Errorf("local report %s already exists", localFileName)
+	}
+	for _, uploadFileName := range uploadFileNames {
+		if uploadFileName == "" {
+			continue
+		}
+		if _, err := os.Stat(uploadFileName); err == nil {
+			u.deleteFiles(countFiles)
+			return nil, fmt.Errorf("report %s already exists", uploadFileName)
+		}
+	}
+	// write the uploadable files
+	var errUpload, errLocal error
+	for i, uploadFileName := range uploadFileNames {
+		if uploadFileName == "" {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(uploadFileName), 0777); err != nil {
+			errUpload = err
+			break
+		}
+		if _, errUpload = exclusiveWrite(uploadFileName, uploadContents[i]); errUpload != nil {
+			break
+		}
+	}
+	// write the local file
+	_, errLocal = exclusiveWrite(localFileName, localContents)
+	/*  Wrote the files */
+
+	// even though these errors won't occur, what should happen
+	// if errUpload == nil and it is ok to upload, and errLocal != nil?
+	if errLocal != nil {
+		return nil, fmt.Errorf("failed to write local file %s (%v)", localFileName, errLocal)
+	}
+	if errUpload != nil {
+		return nil, fmt.Errorf("failed to write upload file (%v)", errUpload)
+	}
+	u.logger.Printf("Created reports for %s, deleting %d count files", expiryDate, len(countFiles))
+	u.deleteFiles(countFiles)
+	if mode == "review" {
+		u.logger.Printf("Holding reports for %s for review", expiryDate)
+		return make([]string, len(u.dests)), nil
+	}
+	return uploadFileNames, nil
+}
+
+// builtReports are the reports built from the count files of a week.
+type builtReports struct {
+	local   *telemetry.Report   // the local report, with all counters
+	uploads []*telemetry.Report // the report for each destination, or nil if none
+	// decisions explains the upload reports, for each destination
+	decisions [][]CounterDecision
+}
+
+// Reasons for which counters are dropped from upload reports.
+const (
+	dropNotInConfig = "not in config"
+	dropNotApproved = "version not approved"
+	dropAboveRate   = "X above rate"
+	dropAsOf        = "before as-of date"
+	dropTooOld      = "too old"
+	dropMode        = "mode is not on"
+	dropNoConfig    = "no upload config"
+)
+
+// buildReports builds the local report and the upload report of each
+// destination by combining all the count files for the expiryDate, given
+// the telemetry mode and its as-of date.
+func (u *uploader) buildReports(start time.Time, expiryDate string, countFiles []string, lastWeeks []string, mode string, asof time.Time) (*builtReports, error) {
+	var dropped string // reason for which no counter is uploaded
+	if !UploadsEnabled(mode) {
 		u.logger.Printf("No upload config or mode %q is not 'on'", mode)
-		uploadOK = false // no config, nothing to upload
+		dropped = dropMode // no config, nothing to upload
 	}
 	if u.tooOld(expiryDate, u.startTime) {
 		u.logger.Printf("Expiry date %s is too old", expiryDate)
-		uploadOK = false
+		dropped = dropTooOld
 	}
 	// If the mode is recorded with an asof date, don't upload if the report
 	// includes any data on or before the asof date.
 	if !asof.IsZero() && !asof.Before(start) {
 		u.logger.Printf("As-of date %s is not before start %s", asof, start)
-		uploadOK = false
+		dropped = dropAsOf
 	}
 	// TODO(rfindley): check that all the x.Meta are consistent for GOOS, GOARCH, etc.
 	report := &telemetry.Report{
-		Config:   u.configVersion,
+		Config:   u.dests[0].configVersion,
 		X:        computeRandom(), // json encodes all the bits
 		Week:     expiryDate,
-		LastWeek: lastWeek,
-	}
-	if report.X > u.config.SampleRate && u.config.SampleRate > 0 {
-		u.logger.Printf("X: %f > SampleRate:%f, not uploadable", report.X, u.config.SampleRate)
-		uploadOK = false
+		LastWeek: lastWeeks[0],
 	}
-	var succeeded bool
+	var (
+		succeeded bool
+		flushed   = true // whether all the count files were flushed
+		end       time.Time
+	)
 	for _, f := range countFiles {
 		fok := false
 		x, err := u.parseCountFile(f)
@@ -156,6 +311,12 @@ func (u *uploader) createReport(start time.Time, expiryDate string, countFiles [
 			u.logger.Printf("Unparseable count file %s: %v", filepath.Base(f), err)
 			continue
 		}
+		if _, fend, err := metaSpan(x.Meta); err == nil {
+			flushed = flushed && !isMidnight(fend)
+			if fend.After(end) {
+				end = fend
+			}
+		}
 		prog := findProgReport(x.Meta, report)
 		for k, v := range x.Count {
 			if counter.IsStackCounter(k) {
@@ -173,104 +334,225 @@ func (u *uploader) createReport(start time.Time, expiryDate string, countFiles [
 		}
 	}
 	if !succeeded {
-		return "", fmt.Errorf("none of the %d count files for %s contained counters", len(countFiles), expiryDate)
+		return nil, fmt.Errorf("none of the %d count files for %s contained counters", len(countFiles), expiryDate)
 	}
-	// 1. generate the local report
-	localContents, err := json.MarshalIndent(report, "", " ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal report for %s: %v", expiryDate, err)
+	report.Partial = partialWeek(start, end, flushed)
+	if report.Partial > 0 {
+		u.logger.Printf("Report for %s is partial, covering %.2f of a week", expiryDate, report.Partial)
 	}
-	// check that the report can be read back
-	// TODO(pjw): remove for production?
-	var report2 telemetry.Report
-	if err := json.Unmarshal(localContents, &report2); err != nil {
-		return "", fmt.Errorf("failed to unmarshal local report for %s: %v", expiryDate, err)
+
+	// create the uploadable version for each destination
+	reports := &builtReports{
+		local:     report,
+		uploads:   make([]*telemetry.Report, len(u.dests)),
+		decisions: make([][]CounterDecision, len(u.dests)),
 	}
+	for i, d := range u.dests {
+		dropped := dropped
+		if dropped == "" && d.config == nil {
+			u.logger.Printf("No upload config for %v, not uploadable", d)
+			dropped = dropNoConfig
+		}
+		// The upload endpoint is sampled with the X of the local
+		// report; other destinations are sampled independently.
+		x := report.X
+		if i > 0 {
+			x = computeRandom()
+		}
+		if dropped == "" && x > d.config.SampleRate && d.config.SampleRate > 0 {
+			u.logger.Printf("X: %f > SampleRate:%f of %v, not uploadable", x, d.config.SampleRate, d)
+			dropped = dropAboveRate
+		}
+		if dropped != "" {
+			reports.decisions[i] = dropAll(report, dropped)
+			continue
+		}
+		reports.uploads[i], reports.decisions[i] = uploadReport(report, d.config, d.configVersion, x, lastWeeks[i])
+	}
+	return reports, nil
+}
 
-	var uploadContents []byte
-	if uploadOK {
-		// 2. create the uploadable version
-		cfg := config.NewConfig(u.config)
-		upload := &telemetry.Report{
-			Week:     report.Week,
-			LastWeek: report.LastWeek,
-			X:        report.X,
-			Config:   report.Config,
-		}
-		for _, p := range report.Programs {
-			// does the uploadConfig want this program?
-			// if so, copy over the Stacks and Counters
-			// that the uploadConfig mentions.
-			if !cfg.HasGoVersion(p.GoVersion) || !cfg.HasProgram(p.Program) || !cfg.HasVersion(p.Program, p.Version) {
-				continue
+// A CounterDecision records whether a counter of the local report is
+// included in an upload report, and if not, why.
+type CounterDecision struct {
+	Program   string // program path
+	Version   string // program version
+	GoVersion string // Go version of the program
+	GOOS      string
+	GOARCH    string
+	Counter   string // counter name; stack counter names include the stack
+	Kept      bool   // whether the counter is uploaded
+	Noised    bool   // whether the counter is uploaded with noise
+	// Reason is why the counter is not uploaded: "not in config", "version
+	// not approved", "X above rate", "before as-of date", "too old", "mode
+	// is not on", or "no upload config".
+	Reason string
+}
+
+// decide returns the decision for the named counter of the program report p.
+func decide(p *telemetry.ProgramReport, name string, reason string) CounterDecision {
+	return CounterDecision{
+		Program:   p.Program,
+		Version:   p.Version,
+		GoVersion: p.GoVersion,
+		GOOS:      p.GOOS,
+		GOARCH:    p.GOARCH,
+		Counter:   name,
+		Kept:      reason == "",
+		Reason:    reason,
+	}
+}
+
+// dropAll returns the decisions to drop every counter of the report, for
+// the given reason.
+func dropAll(report *telemetry.Report, reason string) []CounterDecision {
+	var decisions []CounterDecision
+	for _, p := range report.Programs {
+		for k := range p.Counters {
+			decisions = append(decisions, decide(p, k, reason))
+		}
+		for k := range p.Stacks {
+			decisions = append(decisions, decide(p, k, reason))
+		}
+	}
+	sortDecisions(decisions)
+	return decisions
+}
+
+func sortDecisions(decisions []CounterDecision) {
+	sort.Slice(decisions, func(i, j int) bool {
+		x, y := decisions[i], decisions[j]
+		if x.Program != y.Program {
+			return x.Program < y.Program
+		}
+		if x.Version != y.Version {
+			return x.Version < y.Version
+		}
+		if x.GoVersion != y.GoVersion {
+			return x.GoVersion < y.GoVersion
+		}
+		if x.GOOS != y.GOOS {
+			return x.GOOS < y.GOOS
+		}
+		if x.GOARCH != y.GOARCH {
+			return x.GOARCH < y.GOARCH
+		}
+		return x.Counter < y.Counter
+	})
+}
+
+// uploadReport returns the uploadable version of report for a destination
+// with the given upload config, sampled with the given x, along with the
+// decision for each counter of the report.
+func uploadReport(report *telemetry.Report, uploadConfig *telemetry.UploadConfig, configVersion string, x float64, lastWeek string) (*telemetry.Report, []CounterDecision) {
+	cfg := config.NewConfig(uploadConfig)
+	upload := &telemetry.Report{
+		Week:     report.Week,
+		LastWeek: lastWeek,
+		X:        x,
+		Config:   configVersion,
+		Partial:  report.Partial,
+	}
+	var decisions []CounterDecision
+	for _, p := range report.Programs {
+		// does the uploadConfig want this program?
+		// if so, copy over the Stacks and Counters
+		// that the uploadConfig mentions.
+		var dropped string
+		switch {
+		case !cfg.HasProgram(p.Program):
+			dropped = dropNotInConfig
+		case !cfg.HasGoVersion(p.GoVersion) || !cfg.HasVersion(p.Program, p.Version):
+			dropped = dropNotApproved
+		}
+		if dropped != "" {
+			for k := range p.Counters {
+				decisions = append(decisions, decide(p, k, dropped))
 			}
-			x := &telemetry.ProgramReport{
-				Program:   p.Program,
-				Version:   p.Version,
-				GOOS:      p.GOOS,
-				GOARCH:    p.GOARCH,
-				GoVersion: p.GoVersion,
-				Counters:  make(map[string]int64),
-				Stacks:    make(map[string]int64),
+			for k := range p.Stacks {
+				decisions = append(decisions, decide(p, k, dropped))
 			}
-			upload.Programs = append(upload.Programs, x)
-			for k, v := range p.Counters {
-				if cfg.HasCounter(p.Program, k) && report.X <= cfg.Rate(p.Program, k) {
-					x.Counters[k] = v
+			continue
+		}
+		x := &telemetry.ProgramReport{
+			Program:   p.Program,
+			Version:   p.Version,
+			GOOS:      p.GOOS,
+			GOARCH:    p.GOARCH,
+			GoVersion: p.GoVersion,
+			Counters:  make(map[string]int64),
+			Stacks:    make(map[string]int64),
+		}
+		upload.Programs = append(upload.Programs, x)
+		for k, v := range p.Counters {
+			reason := dropNotInConfig
+			if cfg.HasCounter(p.Program, k) {
+				reason = dropAboveRate
+				if upload.X <= cfg.Rate(p.Program, k) {
+					reason = ""
+					if cfg.Epsilon(p.Program, k) == 0 {
+						x.Counters[k] = v
+					} // otherwise, reported with noise below
 				}
 			}
-			// and the same for Stacks
-			// this can be made more efficient, when it matters
-			for k, v := range p.Stacks {
-				before, _, _ := strings.Cut(k, "\n")
-				if cfg.HasStack(p.Program, before) && report.X <= cfg.Rate(p.Program, before) {
+			decision := decide(p, k, reason)
+			decision.Noised = reason == "" && cfg.Epsilon(p.Program, k) > 0
+			decisions = append(decisions, decision)
+		}
+		addNoisyCounters(cfg, upload.X, p, x)
+		// and the same for Stacks
+		// this can be made more efficient, when it matters
+		for k, v := range p.Stacks {
+			before, _, _ := strings.Cut(k, "\n")
+			reason := dropNotInConfig
+			if cfg.HasStack(p.Program, before) {
+				reason = dropAboveRate
+				if upload.X <= cfg.Rate(p.Program, before) {
 					x.Stacks[k] = v
+					reason = ""
 				}
 			}
-		}
-
-		uploadContents, err = json.MarshalIndent(upload, "", " ")
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal upload report for %s: %v", expiryDate, err)
+			decisions = append(decisions, decide(p, k, reason))
 		}
 	}
-	localFileName := filepath.Join(u.dir.LocalDir(), "local."+expiryDate+".json")
-	uploadFileName := filepath.Join(u.dir.LocalDir(), expiryDate+".json")
-
-	/* Prepare to write files */
-	// if either file exists, someone has been here ahead of us
-	// (there is still a race, but this check shortens the open window)
-	if _, err := os.Stat(localFileName); err == nil {
-		u.deleteFiles(countFiles)
-		return "", fmt.Errorf("local report %s already exists", localFileName)
-	}
-	if _, err := os.Stat(uploadFileName); err == nil {
-		u.deleteFiles(countFiles)
-		return "", fmt.Errorf("report %s already exists", uploadFileName)
-	}
-	// write the uploadable file
-	var errUpload, errLocal error
-	if uploadOK {
-		_, errUpload = exclusiveWrite(uploadFileName, uploadContents)
-	}
-	// write the local file
-	_, errLocal = exclusiveWrite(localFileName, localContents)
-	/*  Wrote the files */
+	sortDecisions(decisions)
+	return upload, decisions
+}
 
-	// even though these errors won't occur, what should happen
-	// if errUpload == nil and it is ok to upload, and errLocal != nil?
-	if errLocal != nil {
-		return "", fmt.Errorf("failed to write local file %s (%v)", localFileName, errLocal)
-	}
-	if errUpload != nil {
-		return "", fmt.Errorf("failed to write upload file %s (%v)", uploadFileName, errUpload)
-	}
-	u.logger.Printf("Created %s, deleting %d count files", filepath.Base(uploadFileName), len(countFiles))
-	u.deleteFiles(countFiles)
-	if uploadOK {
-		return uploadFileName, nil
+// addNoisyCounters adds to the upload program report x the counters of
+// the program report p that are reported with noise.
+//
+// Every bucket of such a counter is reported, with value 1 if it was
+// incremented and 0 otherwise, using randomized response: the truth is
+// reported with probability [config.TruthProbability] of the counter's
+// epsilon, and its opposite otherwise. Since the buckets of a counter are
+// always reported together, the server can tell how many reports include
+// the counter, and correct for the noise. The counter's chart is listed in
+// x.Noised, so that the server corrects only noisy data.
+func addNoisyCounters(cfg *config.Config, sample float64, p, x *telemetry.ProgramReport) {
+	for _, pc := range cfg.Programs {
+		if pc.Name != p.Program {
+			continue
+		}
+		for _, c := range pc.Counters {
+			if c.Epsilon <= 0 || sample > c.Rate {
+				continue
+			}
+			truth := config.TruthProbability(c.Epsilon)
+			chart, _, _ := strings.Cut(c.Name, ":")
+			x.Noised = append(x.Noised, chart)
+			for _, name := range config.Expand(c.Name) {
+				incremented := p.Counters[name] > 0
+				if computeRandom() > truth {
+					incremented = !incremented
+				}
+				x.Counters[name] = 0
+				if incremented {
+					x.Counters[name] = 1
+				}
+			}
+		}
 	}
-	return "", nil
 }
 
 // exclusiveWrite attempts to create filename exclusively, and if successful,
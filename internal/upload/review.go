@@ -0,0 +1,189 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+// This file defines the review of reports. In review mode, upload reports
+// are held in the pending directory of their destination until the user
+// approves them, which makes them ready for upload, or rejects them, which
+// moves them to the rejected directory, where they are kept for the record.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/telemetry/internal/telemetry"
+)
+
+// A PendingReport is an upload report held for review.
+type PendingReport struct {
+	ID          string            // the report's week, prefixed by "NAME/" for the destination NAME
+	Destination string            // name of the destination, or "" for the upload endpoint
+	Week        string            // the report's week, as YYYY-MM-DD
+	Report      *telemetry.Report // the report that would be uploaded
+	Local       *telemetry.Report // the local report of the same week, or nil if it is missing
+}
+
+// A Review is the state of the reports held for review.
+type Review struct {
+	Pending  []*PendingReport
+	Rejected int // number of rejected reports
+}
+
+// ReadReview returns the reports of the telemetry directory that are held
+// for review, for all destinations, and the number of rejected reports.
+func ReadReview(rcfg RunConfig) (*Review, error) {
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+
+	review := new(Review)
+	for _, d := range u.reviewDests() {
+		fis, err := os.ReadDir(d.pendingDir())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if !reportNameRE.MatchString(fi.Name()) {
+				continue
+			}
+			week := strings.TrimSuffix(fi.Name(), ".json")
+			r := &PendingReport{
+				ID:          reviewID(d.name, week),
+				Destination: d.name,
+				Week:        week,
+				Report:      new(telemetry.Report),
+			}
+			if err := readReport(filepath.Join(d.pendingDir(), fi.Name()), r.Report); err != nil {
+				return nil, err
+			}
+			local := new(telemetry.Report)
+			if err := readReport(filepath.Join(u.dir.LocalDir(), "local."+fi.Name()), local); err == nil {
+				r.Local = local
+			}
+			review.Pending = append(review.Pending, r)
+		}
+		fis, _ = os.ReadDir(d.rejectedDir())
+		for _, fi := range fis {
+			if strings.HasSuffix(fi.Name(), ".json") {
+				review.Rejected++
+			}
+		}
+	}
+	sort.Slice(review.Pending, func(i, j int) bool {
+		return review.Pending[i].ID < review.Pending[j].ID
+	})
+	return review, nil
+}
+
+func readReport(fname string, report *telemetry.Report) error {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, report); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(fname), err)
+	}
+	return nil
+}
+
+// reviewID returns the ID of the pending report of the given destination
+// and week.
+func reviewID(dest, week string) string {
+	if dest == "" {
+		return week
+	}
+	return dest + "/" + week
+}
+
+// Approve approves the pending report with the given ID, which makes it
+// ready for upload.
+func Approve(rcfg RunConfig, id string) error {
+	return review(rcfg, id, true)
+}
+
+// Reject rejects the pending report with the given ID, so that it is never
+// uploaded.
+func Reject(rcfg RunConfig, id string) error {
+	return review(rcfg, id, false)
+}
+
+func review(rcfg RunConfig, id string, approve bool) error {
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return err
+	}
+	defer u.Close()
+
+	dest, week, ok := strings.Cut(id, "/")
+	if !ok {
+		dest, week = "", id
+	}
+	var d *destination
+	for _, rd := range u.reviewDests() {
+		if rd.name == dest {
+			d = rd
+		}
+	}
+	if d == nil || !reportNameRE.MatchString(week+".json") {
+		return fmt.Errorf("no pending report %q", id)
+	}
+
+	// Don't race with an uploader.
+	unlock, err := u.lock()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no pending report %q", id)
+		}
+		return err
+	}
+	defer unlock()
+
+	pending := filepath.Join(d.pendingDir(), week+".json")
+	if _, err := os.Stat(pending); err != nil {
+		return fmt.Errorf("no pending report %q", id)
+	}
+	dir := d.localDir
+	if !approve {
+		dir = d.rejectedDir()
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(pending, filepath.Join(dir, week+".json")); err != nil {
+		return err
+	}
+	if approve {
+		u.logger.Printf("Approved %s", id)
+	} else {
+		u.logger.Printf("Rejected %s", id)
+	}
+	return nil
+}
+
+// reviewDests returns the destinations that may have reports held for
+// review: the upload endpoint, and every destination that has a local
+// directory, whether or not it is configured.
+func (u *uploader) reviewDests() []*destination {
+	dests := []*destination{u.dests[0]}
+	destDir := filepath.Join(u.dir.LocalDir(), "dest")
+	fis, _ := os.ReadDir(destDir)
+	for _, fi := range fis {
+		if fi.IsDir() && destNameRE.MatchString(fi.Name()) {
+			dests = append(dests, &destination{
+				name:     fi.Name(),
+				localDir: filepath.Join(destDir, fi.Name()),
+			})
+		}
+	}
+	return dests
+}
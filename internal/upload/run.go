@@ -5,31 +5,108 @@
 package upload
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"golang.org/x/telemetry/internal/configstore"
+	"golang.org/x/telemetry/internal/filelock"
 	"golang.org/x/telemetry/internal/telemetry"
 )
 
+// DefaultUploadURL is the endpoint that receives reports by default.
+const DefaultUploadURL = "https://telemetry.go.dev/upload"
+
 // RunConfig configures non-default behavior of a call to Run.
 //
 // All fields are optional, for testing or observability.
 type RunConfig struct {
 	TelemetryDir string    // if set, overrides the telemetry data directory
 	UploadURL    string    // if set, overrides the telemetry upload endpoint
+	Transport    Transport // if set, overrides UploadURL and the HTTP transport used to upload reports
 	LogWriter    io.Writer // if set, used for detailed logging of the upload process
 	Env          []string  // if set, appended to the config download environment
 	StartTime    time.Time // if set, overrides the upload start time
+
+	// Config, if set, is the upload config of the upload endpoint, used
+	// instead of the latest config, which is otherwise downloaded or read
+	// from the cache. ConfigVersion is its version.
+	Config        *telemetry.UploadConfig
+	ConfigVersion string
+
+	// Timeouts bound the time spent uploading, so that a hung go command or
+	// a stalled upload request cannot keep the uploader alive indefinitely.
+	// If a timeout cuts work short, Run returns an error wrapping
+	// [context.DeadlineExceeded]; unfinished reports are retried by a later
+	// run. Zero means no limit.
+	Timeout        time.Duration // if set, limits the entire call to Run
+	ConfigTimeout  time.Duration // if set, limits the upload config download
+	RequestTimeout time.Duration // if set, limits each report upload request
+
+	// Report uploads that fail for transient reasons, such as a network
+	// error or a server error, are retried within the run, with jittered
+	// exponential backoff, or as requested by the server's Retry-After
+	// header. Reports that still fail are retried by a later run.
+	Retries    int           // if set, overrides the number of retries of each report (negative means none)
+	RetryDelay time.Duration // if set, overrides the delay before the first retry
+
+	// Destinations, if set, receive reports in addition to the upload
+	// endpoint.
+	Destinations []Destination
+
+	// DryRun, if set, makes Run build the reports that would be uploaded
+	// in memory, without writing, deleting or uploading anything, and
+	// pass each of them to DryRun. Reports are built as if uploading were
+	// on, whatever the mode.
+	DryRun func(*DryRunReport)
+
+	// Flush, if set, makes the active count files expire at the start of
+	// the run, so that their counters are reported now, in a report marked
+	// as partial, rather than once the files expire. It is meant for
+	// short-lived machines, such as CI runners, that would otherwise never
+	// report their counters. Count files in use by running programs are
+	// not flushed. At most one report is flushed each day. Flush is
+	// ignored in a dry run.
+	Flush bool
+}
+
+// A Destination is an additional destination for reports, such as a
+// private collection server.
+//
+// Each destination receives its own reports, built from the same counter
+// files, but filtered and sampled according to its own upload config.
+// Its uploads are tracked separately from those of other destinations, in
+// the local/dest/NAME and upload/dest/NAME subdirectories of the telemetry
+// directory.
+type Destination struct {
+	// Name identifies the destination. It consists of lower case letters,
+	// digits, '-' and '_'.
+	Name string
+
+	// Transport delivers the reports of the destination.
+	Transport Transport
+
+	// Config returns the upload config of the destination, and its
+	// version. It is called only if uploading is enabled. If it fails, no
+	// reports are created for the destination, but reports that are ready
+	// for upload are still uploaded. It is also called by [Export], which
+	// may run on a machine without network access.
+	Config func(context.Context) (config *telemetry.UploadConfig, version string, _ error)
 }
 
+// destNameRE matches valid destination names.
+var destNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // Run generates and uploads reports, as allowed by the mode file.
 func Run(config RunConfig) error {
 	defer func() {
@@ -37,24 +114,35 @@ func Run(config RunConfig) error {
 			log.Printf("upload recover: %v", err)
 		}
 	}()
-	uploader, err := newUploader(config)
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	uploader, err := newUploader(ctx, config)
 	if err != nil {
 		return err
 	}
 	defer uploader.Close()
-	return uploader.Run()
+	return uploader.Run(ctx)
 }
 
 // uploader encapsulates a single upload operation, carrying parameters and
 // shared state.
 type uploader struct {
-	// config is used to select counters to upload.
-	config        *telemetry.UploadConfig //
-	configVersion string                  // version of the config
-	dir           telemetry.Dir           // the telemetry dir to process
+	dir   telemetry.Dir  // the telemetry dir to process
+	dests []*destination // destinations of reports; the first is the upload endpoint
 
-	uploadServerURL string
-	startTime       time.Time
+	startTime      time.Time
+	requestTimeout time.Duration // if nonzero, the limit on each upload request
+	timedOut       bool          // whether an upload request timed out
+	retries        int           // number of retries of a failed upload request
+	retryDelay     time.Duration // delay before the first retry
+	dryRun         func(*DryRunReport)
+	flushNow       bool  // whether to flush the active count files
+	uploaded       int   // number of reports uploaded by the run
+	uploadErr      error // the last upload failure of the run
 
 	cache parsedCache
 
@@ -62,11 +150,92 @@ type uploader struct {
 	logger  *log.Logger
 }
 
+// A destination is a destination of reports, with its own upload config
+// and bookkeeping directories.
+type destination struct {
+	name      string // "" for the upload endpoint
+	transport Transport
+
+	// config is used to select counters to upload. If it is nil, no
+	// reports are created for the destination.
+	config        *telemetry.UploadConfig
+	configVersion string // version of the config
+	fetchConfig   func(context.Context) (*telemetry.UploadConfig, string, error)
+	offlineConfig func(context.Context) (*telemetry.UploadConfig, string, error) // like fetchConfig, but without network access
+
+	localDir  string // holds reports that are ready for upload
+	uploadDir string // holds copies of the uploaded reports
+}
+
+// pendingDir is the directory holding the reports of d that are waiting
+// for review.
+func (d *destination) pendingDir() string { return filepath.Join(d.localDir, "pending") }
+
+// rejectedDir is the directory holding the reports of d that were rejected
+// in review.
+func (d *destination) rejectedDir() string { return filepath.Join(d.localDir, "rejected") }
+
+func (d *destination) String() string {
+	if d.name == "" {
+		return fmt.Sprint(d.transport)
+	}
+	return fmt.Sprintf("%s (%v)", d.name, d.transport)
+}
+
 // newUploader creates a new uploader to use for running the upload for the
 // given config.
 //
-// Uploaders should only be used for one call to [uploader.Run].
-func newUploader(rcfg RunConfig) (*uploader, error) {
+// Uploaders should only be used for one call to [uploader.Run]. The upload
+// config is downloaded within the lifetime of ctx.
+func newUploader(ctx context.Context, rcfg RunConfig) (*uploader, error) {
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the upload configs.
+	if mode, _ := u.dir.Mode(); UploadsEnabled(mode) || u.dryRun != nil {
+		// golang/go#68946: only download the upload config if it will be used.
+		//
+		// TODO(rfindley): This is a narrow change aimed at minimally fixing the
+		// associated bug. In the future, we should read the mode only once during
+		// the upload process.
+		dctx := ctx
+		if rcfg.ConfigTimeout > 0 {
+			var cancel context.CancelFunc
+			dctx, cancel = context.WithTimeout(ctx, rcfg.ConfigTimeout)
+			defer cancel()
+		}
+		for _, d := range u.dests {
+			config, version, err := d.fetchConfig(dctx)
+			if err != nil {
+				countOutcome(outcomeNoConfig)
+				if d.name == "" {
+					u.logger.Printf("Failed to download upload config: %v", err)
+					if u.dryRun == nil {
+						u.updateStatus(func(s *Status) {
+							s.LastAttempt = u.startTime
+							s.LastError = "failed to download upload config: " + err.Error()
+						})
+					}
+					u.Close()
+					return nil, err
+				}
+				// Other destinations must not prevent uploads to the
+				// upload endpoint.
+				u.logger.Printf("Failed to fetch upload config of destination %s: %v", d.name, err)
+				d.config = nil
+				continue
+			}
+			d.config, d.configVersion = config, version
+		}
+	}
+	return u, nil
+}
+
+// newLocalUploader is like newUploader, but does not download the upload
+// configs, for operations that only upload existing reports.
+func newLocalUploader(rcfg RunConfig) (*uploader, error) {
 	// Determine the upload directory.
 	var dir telemetry.Dir
 	if rcfg.TelemetryDir != "" {
@@ -75,10 +244,71 @@ func newUploader(rcfg RunConfig) (*uploader, error) {
 		dir = telemetry.Default
 	}
 
-	// Determine the upload URL.
-	uploadURL := rcfg.UploadURL
-	if uploadURL == "" {
-		uploadURL = "https://telemetry.go.dev/upload"
+	// Set the start time, if it is not provided.
+	startTime := time.Now().UTC()
+	if !rcfg.StartTime.IsZero() {
+		startTime = rcfg.StartTime
+	}
+
+	// Determine the destinations: the upload endpoint, and any others.
+	transport := rcfg.Transport
+	if transport == nil {
+		uploadURL := rcfg.UploadURL
+		if uploadURL == "" {
+			uploadURL = DefaultUploadURL
+		}
+		transport = &HTTPTransport{URL: uploadURL}
+	}
+	cache := configstore.Cache{
+		File:   dir.ConfigCacheFile(),
+		TTL:    configstore.DefaultCacheTTL,
+		MaxAge: configstore.DefaultCacheMaxAge,
+		// A dry run must not modify the telemetry directory.
+		ReadOnly: rcfg.DryRun != nil,
+	}
+	endpoint := &destination{
+		transport: transport,
+		fetchConfig: func(ctx context.Context) (*telemetry.UploadConfig, string, error) {
+			return cache.Latest(ctx, startTime, rcfg.Env)
+		},
+		offlineConfig: func(context.Context) (*telemetry.UploadConfig, string, error) {
+			return cache.Cached(startTime, rcfg.Env)
+		},
+		localDir:  dir.LocalDir(),
+		uploadDir: dir.UploadDir(),
+	}
+	if rcfg.Config != nil {
+		endpoint.fetchConfig = func(context.Context) (*telemetry.UploadConfig, string, error) {
+			return rcfg.Config, rcfg.ConfigVersion, nil
+		}
+		endpoint.offlineConfig = endpoint.fetchConfig
+	}
+	dests := []*destination{endpoint}
+	seen := make(map[string]bool)
+	for _, dest := range rcfg.Destinations {
+		if !destNameRE.MatchString(dest.Name) {
+			return nil, fmt.Errorf("invalid destination name %q", dest.Name)
+		}
+		if seen[dest.Name] {
+			return nil, fmt.Errorf("duplicate destination %q", dest.Name)
+		}
+		seen[dest.Name] = true
+		if dest.Transport == nil || dest.Config == nil {
+			return nil, fmt.Errorf("destination %s has no transport or config", dest.Name)
+		}
+		dests = append(dests, &destination{
+			name:          dest.Name,
+			transport:     dest.Transport,
+			fetchConfig:   dest.Config,
+			offlineConfig: dest.Config,
+			localDir:      filepath.Join(dir.LocalDir(), "dest", dest.Name),
+			uploadDir:     filepath.Join(dir.UploadDir(), "dest", dest.Name),
+		})
+	}
+	// Until the configs are fetched, no counters are uploaded.
+	for _, d := range dests {
+		d.config = &telemetry.UploadConfig{}
+		d.configVersion = "v0.0.0-0"
 	}
 
 	// Determine the upload logger.
@@ -111,39 +341,24 @@ func newUploader(rcfg RunConfig) (*uploader, error) {
 	}
 	logger := log.New(logWriter, "", log.Ltime|log.Lmicroseconds|log.Lshortfile)
 
-	// Fetch the upload config, if it is not provided.
-	var (
-		config        *telemetry.UploadConfig
-		configVersion string
-	)
-
-	if mode, _ := dir.Mode(); mode == "on" {
-		// golang/go#68946: only download the upload config if it will be used.
-		//
-		// TODO(rfindley): This is a narrow change aimed at minimally fixing the
-		// associated bug. In the future, we should read the mode only once during
-		// the upload process.
-		config, configVersion, err = configstore.Download("latest", rcfg.Env)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		config = &telemetry.UploadConfig{}
-		configVersion = "v0.0.0-0"
+	retries := defaultRetries
+	if rcfg.Retries != 0 {
+		retries = max(rcfg.Retries, 0)
 	}
-
-	// Set the start time, if it is not provided.
-	startTime := time.Now().UTC()
-	if !rcfg.StartTime.IsZero() {
-		startTime = rcfg.StartTime
+	retryDelay := defaultRetryDelay
+	if rcfg.RetryDelay > 0 {
+		retryDelay = rcfg.RetryDelay
 	}
 
 	return &uploader{
-		config:          config,
-		configVersion:   configVersion,
-		dir:             dir,
-		uploadServerURL: uploadURL,
-		startTime:       startTime,
+		dir:            dir,
+		dests:          dests,
+		startTime:      startTime,
+		requestTimeout: rcfg.RequestTimeout,
+		retries:        retries,
+		retryDelay:     retryDelay,
+		dryRun:         rcfg.DryRun,
+		flushNow:       rcfg.Flush,
 
 		logFile: logFile,
 		logger:  logger,
@@ -158,24 +373,91 @@ func (u *uploader) Close() error {
 	return u.logFile.Close()
 }
 
-// Run generates and uploads reports
-func (u *uploader) Run() error {
+// Run generates and uploads reports, until ctx is done.
+func (u *uploader) Run(ctx context.Context) error {
 	if telemetry.DisabledOnPlatform {
 		return nil
 	}
-	todo := u.findWork()
-	ready, err := u.reports(&todo)
+	if u.dryRun != nil {
+		return u.runDry(ctx)
+	}
+	if mode, _ := u.dir.Mode(); mode == "off" {
+		u.logger.Printf("Telemetry is off: nothing to do")
+		return nil
+	}
+	if err := u.dir.Migrate(); err != nil {
+		u.logger.Printf("Not uploading: %v", err)
+		return fmt.Errorf("telemetry directory is not writable: %v", err)
+	}
+
+	unlock, err := u.lock()
 	if err != nil {
+		if errors.Is(err, errLocked) || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer unlock()
+
+	if u.flushNow {
+		u.flush()
+	}
+	todo := u.findWork()
+	defer u.recordRun(&todo)
+	if err := u.reports(ctx, &todo); err != nil {
 		u.logger.Printf("Error building reports: %v", err)
-		return fmt.Errorf("reports failed: %v", err)
+		return fmt.Errorf("reports failed: %w", err)
+	}
+	for i, d := range u.dests {
+		ready := todo.dests[i].readyfiles
+		u.logger.Printf("Uploading %d reports to %v", len(ready), d)
+		for _, f := range ready {
+			if err := ctx.Err(); err != nil {
+				u.logger.Printf("Stopped uploading: %v", err)
+				return fmt.Errorf("upload stopped: %w", err)
+			}
+			u.uploadReport(ctx, d, f)
+		}
 	}
-	u.logger.Printf("Uploading %d reports", len(ready))
-	for _, f := range ready {
-		u.uploadReport(f)
+	if u.timedOut {
+		return fmt.Errorf("upload request timed out: %w", context.DeadlineExceeded)
 	}
 	return nil
 }
 
+// errLocked is returned by [uploader.lock] if another uploader holds the
+// lock.
+var errLocked = errors.New("another uploader is running")
+
+// lock acquires the upload lock of the telemetry directory: only one
+// uploader may process the directory at a time. The lock is released by
+// calling unlock, or when the process exits, even if it crashes.
+//
+// If another uploader holds the lock, lock returns errLocked; if there is
+// no local directory, it returns an error wrapping [fs.ErrNotExist].
+func (u *uploader) lock() (unlock func(), _ error) {
+	lockfile, err := os.OpenFile(filepath.Join(u.dir.LocalDir(), "upload.lock"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		if os.IsNotExist(err) {
+			u.logger.Printf("No local directory: nothing to upload")
+			return nil, fmt.Errorf("no local directory: %w", err)
+		}
+		return nil, fmt.Errorf("failed to open upload lock: %v", err)
+	}
+	if ok, err := filelock.TryLock(lockfile); !ok {
+		lockfile.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire upload lock: %v", err)
+		}
+		u.logger.Printf("Another uploader is running")
+		return nil, errLocked
+	}
+	return func() {
+		filelock.Unlock(lockfile)
+		lockfile.Close()
+	}, nil
+}
+
 // debugLogFile arranges to write a log file in the given debug directory, if
 // it exists.
 func debugLogFile(debugDir string) (*os.File, error) {
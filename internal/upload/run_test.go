@@ -6,8 +6,11 @@ package upload_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -217,28 +220,60 @@ func TestRun_Retries(t *testing.T) {
 
 	tests := []struct {
 		initialStatus   int
+		initialBody     string
 		initialFiles    telemetryFiles
 		filesAfterRetry telemetryFiles
 	}{
 		{
 			http.StatusOK,
+			"",
 			telemetryFiles{localReports: 1, uploadedReports: 1},
 			telemetryFiles{localReports: 1, uploadedReports: 1},
 		},
 		{
 			http.StatusBadRequest,
+			"",
+			telemetryFiles{localReports: 1},
+			telemetryFiles{localReports: 1},
+		},
+		{
+			http.StatusRequestEntityTooLarge,
+			"",
+			telemetryFiles{localReports: 1},
+			telemetryFiles{localReports: 1},
+		},
+		{
+			http.StatusUnprocessableEntity,
+			"",
+			telemetryFiles{localReports: 1},
+			telemetryFiles{localReports: 1},
+		},
+		{
+			http.StatusForbidden, // e.g. from a misconfigured proxy
+			"",
+			telemetryFiles{localReports: 1, unuploadedReports: 1},
+			telemetryFiles{localReports: 1, uploadedReports: 1},
+		},
+		{
+			http.StatusForbidden,
+			"rejected: unknown program\n",
 			telemetryFiles{localReports: 1},
 			telemetryFiles{localReports: 1},
 		},
 		{
 			http.StatusInternalServerError,
+			"",
 			telemetryFiles{localReports: 1, unuploadedReports: 1},
 			telemetryFiles{localReports: 1, uploadedReports: 1},
 		},
 	}
 
 	for _, test := range tests {
-		t.Run(fmt.Sprint(test.initialStatus), func(t *testing.T) {
+		name := fmt.Sprint(test.initialStatus)
+		if test.initialBody != "" {
+			name += "-body"
+		}
+		t.Run(name, func(t *testing.T) {
 			telemetryDir := t.TempDir()
 			if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
 				t.Fatalf("failed to run program: %s", out)
@@ -247,6 +282,7 @@ func TestRun_Retries(t *testing.T) {
 			// Start an upload server that returns the given status code.
 			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(test.initialStatus)
+				fmt.Fprint(w, test.initialBody)
 			}))
 			t.Cleanup(srv.Close)
 
@@ -265,6 +301,7 @@ func TestRun_Retries(t *testing.T) {
 				TelemetryDir: telemetryDir,
 				UploadURL:    srv.URL,
 				Env:          env,
+				RetryDelay:   time.Millisecond,
 			}
 			if err := upload.Run(badCfg); err != nil {
 				t.Fatal(err)
@@ -285,6 +322,686 @@ func TestRun_Retries(t *testing.T) {
 	}
 }
 
+func TestRun_RetryBackoff(t *testing.T) {
+	// Check that transient upload failures are retried within a run, as
+	// requested by the Retry-After header, if any.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+
+	tests := []struct {
+		name         string
+		retryAfter   string // Retry-After header of failed requests
+		failures     int    // number of failed requests before success
+		wantRequests int
+		wantFiles    telemetryFiles
+	}{
+		{"backoff", "", 2, 3, telemetryFiles{localReports: 1, uploadedReports: 1}},
+		{"retry-after", "0", 3, 4, telemetryFiles{localReports: 1, uploadedReports: 1}},
+		{"retry-after-date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 1, 2,
+			telemetryFiles{localReports: 1, uploadedReports: 1}},
+		{"too many failures", "", 5, 4, telemetryFiles{localReports: 1, unuploadedReports: 1}},
+		{"retry-after too long", "3600", 1, 1, telemetryFiles{localReports: 1, unuploadedReports: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			telemetryDir := t.TempDir()
+			if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+				t.Fatalf("failed to run program: %s", out)
+			}
+
+			cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+			var (
+				mu       sync.Mutex
+				requests int
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				requests++
+				if requests <= test.failures {
+					if test.retryAfter != "" {
+						w.Header().Set("Retry-After", test.retryAfter)
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			t.Cleanup(srv.Close)
+			cfg.UploadURL = srv.URL
+			cfg.RetryDelay = time.Millisecond
+
+			if err := upload.Run(cfg); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			if requests != test.wantRequests {
+				t.Errorf("got %d upload requests, want %d", requests, test.wantRequests)
+			}
+			mu.Unlock()
+			checkTelemetryFiles(t, telemetryDir, test.wantFiles)
+		})
+	}
+}
+
+func TestRun_Transports(t *testing.T) {
+	// Check that reports are uploaded through the configured transport,
+	// with the same bookkeeping whatever the transport.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+
+	// setup returns a telemetry directory with one report ready to upload,
+	// and the run config to upload it.
+	setup := func(t *testing.T) (string, upload.RunConfig) {
+		telemetryDir := t.TempDir()
+		if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+			t.Fatalf("failed to run program: %s", out)
+		}
+		cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+		cfg.UploadURL = "http://invalid.example" // must not be used
+		cfg.RetryDelay = time.Millisecond
+		return telemetryDir, cfg
+	}
+
+	t.Run("mem", func(t *testing.T) {
+		telemetryDir, cfg := setup(t)
+		transport := new(upload.MemTransport)
+		cfg.Transport = transport
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		reports := transport.Reports()
+		if len(reports) != 1 || !strings.Contains(string(reports[0].Report), "counter1") {
+			t.Errorf("got uploaded reports %+v, want one with counter1", reports)
+		}
+		checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		telemetryDir, cfg := setup(t)
+		spool := filepath.Join(t.TempDir(), "spool")
+		cfg.Transport = &upload.DirTransport{Dir: spool}
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		spooled, err := filepath.Glob(filepath.Join(spool, "*.json"))
+		if err != nil || len(spooled) != 1 {
+			t.Fatalf("got spooled reports %v (err=%v), want 1", spooled, err)
+		}
+		if !regexp.MustCompile(`^\d{4}-\d\d-\d\d\.[0-9a-f]{16}\.json$`).MatchString(filepath.Base(spooled[0])) {
+			t.Errorf("spooled report has name %s, want DATE.HASH.json", filepath.Base(spooled[0]))
+		}
+		checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+	})
+
+	t.Run("http client", func(t *testing.T) {
+		telemetryDir, cfg := setup(t)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Test") != "client" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		client := &http.Client{Transport: headerTransport{"X-Test", "client"}}
+		cfg.Transport = &upload.HTTPTransport{URL: srv.URL, Client: client}
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		telemetryDir, cfg := setup(t)
+		cfg.Transport = &upload.MemTransport{Fail: func(string) error {
+			return fmt.Errorf("%w: no thanks", upload.ErrRejected)
+		}}
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1})
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		telemetryDir, cfg := setup(t)
+		failures := 0
+		transport := &upload.MemTransport{Fail: func(string) error {
+			if failures++; failures < 3 {
+				return &upload.RetryAfterError{Delay: time.Millisecond, Err: errors.New("busy")}
+			}
+			return nil
+		}}
+		cfg.Transport = transport
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(transport.Reports()); got != 1 {
+			t.Errorf("got %d uploaded reports, want 1", got)
+		}
+		checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+	})
+}
+
+// headerTransport is an http.RoundTripper that sets a request header.
+type headerTransport struct{ key, value string }
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.key, t.value)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestExportImport(t *testing.T) {
+	// Check that reports exported from one telemetry directory are
+	// uploaded, once, by importing them into another.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	// Create a report that cannot be uploaded, as on an offline machine.
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	cfg.Retries = -1
+	cfg.Transport = &upload.MemTransport{Fail: func(string) error {
+		return errors.New("offline")
+	}}
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, unuploadedReports: 1})
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	names, err := upload.Export(cfg, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("Export returned %v, want one report", names)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+
+	// Exported reports are no longer uploaded, or exported.
+	transport := new(upload.MemTransport)
+	cfg.Transport = transport
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := transport.Reports(); len(got) != 0 {
+		t.Errorf("Run uploaded %d exported reports", len(got))
+	}
+	if names, err := upload.Export(cfg, filepath.Join(t.TempDir(), "again.tar.gz")); err != nil || len(names) != 0 {
+		t.Errorf("second Export returned %v, %v, want no reports", names, err)
+	}
+
+	// Import the bundle on a connected machine, twice.
+	icfg := upload.RunConfig{
+		TelemetryDir: t.TempDir(),
+		LogWriter:    testWriter{"", t},
+		Transport:    transport,
+	}
+	wantResults := func(results []upload.ImportResult, skipped bool) {
+		t.Helper()
+		if len(results) != 1 || results[0].Name != names[0] || results[0].Skipped != skipped || results[0].Err != nil {
+			t.Errorf("Import returned %+v, want %s with Skipped=%t", results, names[0], skipped)
+		}
+	}
+	results, err := upload.Import(icfg, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResults(results, false)
+	results, err = upload.Import(icfg, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResults(results, true)
+	reports := transport.Reports()
+	if len(reports) != 1 || "reports/"+reports[0].Date+".json" != names[0] || !strings.Contains(string(reports[0].Report), "counter1") {
+		t.Errorf("got uploaded reports %+v, want one %s report with counter1", reports, names[0])
+	}
+
+	// Corrupted bundles are rejected, and nothing is uploaded.
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, corrupt := range [][]byte{data[:len(data)/2], nil} {
+		bad := filepath.Join(t.TempDir(), "bad.tar.gz")
+		if err := os.WriteFile(bad, corrupt, 0666); err != nil {
+			t.Fatal(err)
+		}
+		icfg.TelemetryDir = t.TempDir()
+		if _, err := upload.Import(icfg, bad); err == nil {
+			t.Errorf("Import of %d-byte corrupted bundle succeeded", len(corrupt))
+		}
+	}
+	if got := len(transport.Reports()); got != 1 {
+		t.Errorf("got %d uploaded reports after corrupted imports, want 1", got)
+	}
+}
+
+func TestImport_UnknownDestination(t *testing.T) {
+	// Check that Import skips the reports for destinations it is not given,
+	// and uploads the others.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	cfg.Retries = -1
+	offline := &upload.MemTransport{Fail: func(string) error {
+		return errors.New("offline")
+	}}
+	cfg.Transport = offline
+	internalConfig := upload.CreateTestUploadConfig(t, []string{"counter1"}, nil)
+	cfg.Destinations = []upload.Destination{{
+		Name:      "internal",
+		Transport: offline,
+		Config: func(context.Context) (*telemetry.UploadConfig, string, error) {
+			return internalConfig, "v9.9.9", nil
+		},
+	}}
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	names, err := upload.Export(cfg, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("Export returned %v, want two reports", names)
+	}
+
+	transport := new(upload.MemTransport)
+	results, err := upload.Import(upload.RunConfig{
+		TelemetryDir: t.TempDir(),
+		LogWriter:    testWriter{"", t},
+		Transport:    transport,
+	}, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded, noDest int
+	for _, r := range results {
+		switch {
+		case r.Err != nil || r.Skipped:
+			t.Errorf("Import returned %+v, want it uploaded or skipped for its destination", r)
+		case r.NoDest:
+			if !strings.HasPrefix(r.Name, "reports/internal/") {
+				t.Errorf("Import skipped %s, want only the reports of the internal destination skipped", r.Name)
+			}
+			noDest++
+		default:
+			uploaded++
+		}
+	}
+	if uploaded != 1 || noDest != 1 {
+		t.Errorf("Import uploaded %d and skipped %d reports, want 1 and 1", uploaded, noDest)
+	}
+	if got := len(transport.Reports()); got != 1 {
+		t.Errorf("got %d uploaded reports, want 1", got)
+	}
+}
+
+func TestExport_Offline(t *testing.T) {
+	// Check that Export builds the reports of expired count files without
+	// network access or a go command, from the cached upload config or
+	// from the one it is given.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-15*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	// The first week is uploaded while online, which caches the config.
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	cfg.Transport = new(upload.MemTransport)
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	// Go offline: the go command, and hence the module proxy, is gone.
+	t.Setenv("PATH", "")
+	cfg.Env = append(cfg.Env, "GOPROXY=off")
+
+	// export exports the reports of the telemetry directory, and returns
+	// them.
+	export := func(cfg upload.RunConfig) []*telemetry.Report {
+		t.Helper()
+		bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		names, err := upload.Export(cfg, bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		f, err := os.Open(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, contents, err := upload.ReadBundle(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var uploads []upload.MemReport
+		for _, c := range contents {
+			uploads = append(uploads, upload.MemReport{Report: c})
+		}
+		return decodeReports(t, uploads)
+	}
+
+	// The cache is keyed by the config environment, which changed, so
+	// there is no config, and no report is built.
+	if reports := export(cfg); len(reports) != 0 {
+		t.Errorf("Export without a cached config exported %d reports, want 0", len(reports))
+	}
+	cfg.Env = cfg.Env[:len(cfg.Env)-1]
+	reports := export(cfg)
+	if len(reports) != 1 {
+		t.Fatalf("Export with a cached config exported %d reports, want 1", len(reports))
+	}
+	checkReport(t, "cached config", reports[0], "v1.2.3", reports[0].LastWeek, "counter1")
+
+	// A config may also be given, as for a machine that was never online.
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-1*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	cfg.StartTime = time.Now().Add(8 * 24 * time.Hour)
+	cfg.Config = upload.CreateTestUploadConfig(t, []string{"counter1"}, nil)
+	cfg.ConfigVersion = "v9.9.9"
+	reports = export(cfg)
+	if len(reports) != 1 {
+		t.Fatalf("Export with a given config exported %d reports, want 1", len(reports))
+	}
+	checkReport(t, "given config", reports[0], "v9.9.9", reports[0].LastWeek, "counter1")
+}
+
+func TestRun_Destinations(t *testing.T) {
+	// Check that each destination receives its own reports, filtered by its
+	// own config, and keeps track of its uploads independently.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1", "counter2")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-15*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	cfg.Retries = -1
+	primary := new(upload.MemTransport)
+	cfg.Transport = primary
+	offline := true
+	internal := &upload.MemTransport{Fail: func(string) error {
+		if offline {
+			return errors.New("offline")
+		}
+		return nil
+	}}
+	internalConfig := upload.CreateTestUploadConfig(t, []string{"counter2"}, nil)
+	cfg.Destinations = []upload.Destination{
+		{
+			Name:      "internal",
+			Transport: internal,
+			Config: func(context.Context) (*telemetry.UploadConfig, string, error) {
+				return internalConfig, "v9.9.9", nil
+			},
+		},
+		{
+			Name:      "broken",
+			Transport: &upload.MemTransport{},
+			Config: func(context.Context) (*telemetry.UploadConfig, string, error) {
+				return nil, "", errors.New("no config")
+			},
+		},
+	}
+
+	// The first week reaches only the upload endpoint.
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	reports := decodeReports(t, primary.Reports())
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports for the upload endpoint, want 1", len(reports))
+	}
+	week1 := reports[0]
+	checkReport(t, "upload endpoint", week1, "v1.2.3", "", "counter1")
+	dir := telemetry.NewDir(telemetryDir)
+	ready, _ := filepath.Glob(filepath.Join(dir.LocalDir(), "dest", "internal", "*.json"))
+	if len(ready) != 1 {
+		t.Errorf("got ready reports %v for the internal destination, want 1", ready)
+	}
+
+	// In the second week, the internal destination receives both reports,
+	// and its last week is still unknown when the second is created.
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	offline = false
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	reports = decodeReports(t, primary.Reports())
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports for the upload endpoint, want 2", len(reports))
+	}
+	checkReport(t, "upload endpoint", reports[1], "v1.2.3", week1.Week, "counter1")
+	reports = decodeReports(t, internal.Reports())
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports for the internal destination, want 2", len(reports))
+	}
+	for _, r := range reports {
+		checkReport(t, "internal destination", r, "v9.9.9", "", "counter2")
+	}
+	uploaded, _ := filepath.Glob(filepath.Join(dir.UploadDir(), "dest", "internal", "*.json"))
+	if len(uploaded) != 2 {
+		t.Errorf("got uploaded reports %v for the internal destination, want 2", uploaded)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 2, uploadedReports: 2})
+}
+
+// decodeReports decodes uploaded reports.
+func decodeReports(t *testing.T, uploads []upload.MemReport) []*telemetry.Report {
+	t.Helper()
+	var reports []*telemetry.Report
+	for _, u := range uploads {
+		r := new(telemetry.Report)
+		if err := json.Unmarshal(u.Report, r); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// checkReport checks that the report has the given config version and last
+// week, and holds only the given counter.
+func checkReport(t *testing.T, dest string, r *telemetry.Report, config, lastWeek, counter string) {
+	t.Helper()
+	if r.Config != config || r.LastWeek != lastWeek {
+		t.Errorf("%s: got report for %s with config %s, last week %q; want %s, %q", dest, r.Week, r.Config, r.LastWeek, config, lastWeek)
+	}
+	if len(r.Programs) != 1 || len(r.Programs[0].Counters) != 1 || r.Programs[0].Counters[counter] == 0 {
+		t.Errorf("%s: got report programs %+v, want one with only %s", dest, r.Programs, counter)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	// Check that a dry run shows the reports that would be uploaded, and why
+	// each counter is kept or dropped, without modifying anything.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1", "counter2")
+
+	tests := []struct {
+		name     string
+		mode     string
+		asof     time.Duration // relative to now
+		wantKept bool          // whether counter1 is kept
+		reason   string        // reason counter2 is dropped
+	}{
+		{"on", "on", -365 * 24 * time.Hour, true, "not in config"},
+		{"local", "local", -365 * 24 * time.Hour, true, "not in config"},
+		{"as-of", "on", -7 * 24 * time.Hour, false, "before as-of date"},
+		{"review as-of", "review", -7 * 24 * time.Hour, false, "before as-of date"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			telemetryDir := t.TempDir()
+			if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+				t.Fatalf("failed to run program: %s", out)
+			}
+			cfg, uploaded := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+			if err := telemetry.NewDir(telemetryDir).SetModeAsOf(test.mode, time.Now().Add(test.asof)); err != nil {
+				t.Fatal(err)
+			}
+			before := dirContents(t, telemetryDir)
+
+			var reports []*upload.DryRunReport
+			cfg.DryRun = func(r *upload.DryRunReport) { reports = append(reports, r) }
+			if err := upload.Run(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("got %d dry run reports, want 1", len(reports))
+			}
+			r := reports[0]
+			if got := r.Report != nil && strings.Contains(fmt.Sprint(r.Report.Programs[0].Counters), "counter1"); got != test.wantKept {
+				t.Errorf("report %+v has counter1: %t, want %t", r.Report, got, test.wantKept)
+			}
+			kept := make(map[string]string)
+			for _, c := range r.Counters {
+				kept[c.Counter] = c.Reason
+				if c.Kept != (c.Reason == "") {
+					t.Errorf("inconsistent decision %+v", c)
+				}
+			}
+			want := map[string]string{"counter1": "", "counter2": test.reason}
+			if !test.wantKept {
+				want["counter1"] = test.reason
+			}
+			if fmt.Sprint(kept) != fmt.Sprint(want) {
+				t.Errorf("got counter decisions %v, want %v", kept, want)
+			}
+
+			if got := uploaded(); len(got) != 0 {
+				t.Errorf("dry run uploaded %d reports", len(got))
+			}
+			if after := dirContents(t, telemetryDir); after != before {
+				t.Errorf("dry run modified the telemetry directory:\nbefore:\n%s\nafter:\n%s", before, after)
+			}
+		})
+	}
+}
+
+// dirContents returns a description of the files in dir, and their sizes.
+func dirContents(t *testing.T, dir string) string {
+	t.Helper()
+	var b strings.Builder
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d\n", path, info.Size())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRun_Review(t *testing.T) {
+	// Check that in review mode, reports are uploaded only once approved,
+	// and rejected reports are never uploaded.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1", "counter2")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-15*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	cfg, uploaded := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	if err := telemetry.NewDir(telemetryDir).SetModeAsOf("review", time.Now().Add(-365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reports are held for review.
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(uploaded()); got != 0 {
+		t.Fatalf("uploaded %d reports held for review", got)
+	}
+	review, err := upload.ReadReview(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(review.Pending) != 2 || review.Rejected != 0 {
+		t.Fatalf("got review %+v, want 2 pending reports", review)
+	}
+	for _, r := range review.Pending {
+		if r.Local == nil || len(r.Local.Programs[0].Counters) != 2 || len(r.Report.Programs[0].Counters) != 1 {
+			t.Errorf("pending report %s: got local report %+v and report %+v, want 2 and 1 counters", r.ID, r.Local, r.Report)
+		}
+	}
+
+	// Approve the first, reject the second.
+	approved, rejected := review.Pending[0], review.Pending[1]
+	if err := upload.Approve(cfg, approved.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Reject(cfg, rejected.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.Approve(cfg, rejected.ID); err == nil {
+		t.Errorf("approving rejected report %s succeeded", rejected.ID)
+	}
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	uploads := uploaded()
+	if len(uploads) != 1 || !strings.Contains(string(uploads[0]), approved.Week) {
+		t.Errorf("got uploads %q, want the report for %s", uploads, approved.Week)
+	}
+	review, err = upload.ReadReview(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(review.Pending) != 0 || review.Rejected != 1 {
+		t.Errorf("got review %+v, want 1 rejected report", review)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 2, uploadedReports: 1})
+}
+
 func TestRun_MultipleUploads(t *testing.T) {
 	// This test checks that [upload.Run] produces multiple reports when counters
 	// span more than a week.
@@ -322,6 +1039,233 @@ func TestRun_MultipleUploads(t *testing.T) {
 	}
 }
 
+func TestRun_Flush(t *testing.T) {
+	// Check that flushing reports the counters of active count files in a
+	// partial report, at most once a day.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now(), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	transport := new(upload.MemTransport)
+	cfg.Transport = transport
+
+	// Without flushing, the active count file is left alone.
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{counterFiles: 1})
+
+	cfg.Flush = true
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	reports := decodeReports(t, transport.Reports())
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	r := reports[0]
+	if today := time.Now().UTC().Format(telemetry.DateOnly); r.Week != today {
+		t.Errorf("got report for week %s, want %s", r.Week, today)
+	}
+	if r.Partial <= 0 || r.Partial >= 1 {
+		t.Errorf("got report with Partial %v, want a fraction of a week", r.Partial)
+	}
+	checkReport(t, "upload endpoint", r, "v1.2.3", "", "counter1")
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+
+	// A second flush on the same day does nothing.
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now(), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(transport.Reports()); got != 1 {
+		t.Errorf("got %d reports after flushing again, want 1", got)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{counterFiles: 1, localReports: 1, uploadedReports: 1})
+}
+
+func TestRun_Status(t *testing.T) {
+	// Check that each run records the outcome of its uploads in the upload
+	// status.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-15*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	cfg.Retries = -1
+	offline := true
+	cfg.Transport = &upload.MemTransport{Fail: func(string) error {
+		if offline {
+			return errors.New("offline")
+		}
+		return nil
+	}}
+	if _, err := upload.ReadStatus(cfg); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadStatus before any upload: got error %v, want fs.ErrNotExist", err)
+	}
+
+	// The first run fails to upload its report.
+	start1 := time.Now().UTC().Truncate(time.Second)
+	cfg.StartTime = start1
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	status, err := upload.ReadStatus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := upload.Status{LastAttempt: start1, LastError: "offline", Config: "v1.2.3", Pending: 1}
+	if *status != want {
+		t.Errorf("after a failed upload, got status %+v, want %+v", *status, want)
+	}
+
+	// The second run uploads it.
+	offline = false
+	start2 := start1.Add(time.Hour)
+	cfg.StartTime = start2
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	status, err = upload.ReadStatus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = upload.Status{LastAttempt: start2, LastSuccess: start2, Config: "v1.2.3"}
+	if *status != want {
+		t.Errorf("after a successful upload, got status %+v, want %+v", *status, want)
+	}
+
+	// The third run has nothing to upload, and leaves the last attempt alone.
+	cfg.StartTime = start2.Add(time.Hour)
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	status, err = upload.ReadStatus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *status != want {
+		t.Errorf("after a run with nothing to upload, got status %+v, want %+v", *status, want)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+}
+
+func TestRun_FlushInUse(t *testing.T) {
+	// Check that flushing leaves alone the count file of a running program,
+	// so that its increments after the flush are reported.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	const syncDirEnv = "_TEST_FLUSH_SYNC_DIR"
+	// waitFor waits for the file with the given name to exist.
+	waitFor := func(name string) bool {
+		for start := time.Now(); time.Since(start) < time.Minute; time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(name); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	prog := regtest.NewProgram(t, "prog", func() int {
+		counter.Inc("counter1")
+		// Tell the test that the count file is in use, and wait for it to
+		// flush before incrementing the counter again.
+		dir := os.Getenv(syncDirEnv)
+		if err := os.WriteFile(filepath.Join(dir, "running"), nil, 0666); err != nil {
+			return 1
+		}
+		if !waitFor(filepath.Join(dir, "flushed")) {
+			return 1
+		}
+		counter.Inc("counter1")
+		return 0
+	})
+	syncDir := t.TempDir()
+	t.Setenv(syncDirEnv, syncDir)
+	telemetryDir := t.TempDir()
+	done := make(chan error, 1)
+	go func() {
+		out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now(), prog)
+		if err != nil {
+			err = fmt.Errorf("%v: %s", err, out)
+		}
+		done <- err
+	}()
+	if !waitFor(filepath.Join(syncDir, "running")) {
+		t.Fatal("program did not start")
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	transport := new(upload.MemTransport)
+	cfg.Transport = transport
+	cfg.Flush = true
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(transport.Reports()); got != 0 {
+		t.Errorf("got %d reports from a count file in use, want 0", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(syncDir, "flushed"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("failed to run program: %v", err)
+	}
+	if err := upload.Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	reports := decodeReports(t, transport.Reports())
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports after the program exited, want 1", len(reports))
+	}
+	if got := reports[0].Programs[0].Counters["counter1"]; got != 2 {
+		t.Errorf("got counter1 = %d, want 2", got)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, uploadedReports: 1})
+}
+
+func TestRun_RequestTimeout(t *testing.T) {
+	// Check that a stalled upload request is abandoned, and the report is kept
+	// for a later run.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog", "counter1")
+	telemetryDir := t.TempDir()
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+
+	cfg, _ := runConfig(t, telemetryDir, []string{"counter1"}, nil)
+	stall := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stall
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(stall) }) // runs before srv.Close
+	cfg.UploadURL = srv.URL
+	cfg.RequestTimeout = 100 * time.Millisecond
+
+	if err := upload.Run(cfg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run with stalled server returned %v, want context.DeadlineExceeded", err)
+	}
+	checkTelemetryFiles(t, telemetryDir, telemetryFiles{localReports: 1, unuploadedReports: 1})
+}
+
 func TestRun_EmptyUpload(t *testing.T) {
 	// This test verifies that an empty counter file does not cause uploads of
 	// another week's reports to fail.
@@ -487,6 +1431,39 @@ func TestRun_ModeHandling(t *testing.T) {
 	}
 }
 
+func TestRun_ConfigCache(t *testing.T) {
+	// This test verifies that the upload config is downloaded once, and
+	// cached for the following runs.
+
+	testenv.SkipIfUnsupportedPlatform(t)
+
+	prog := regtest.NewIncProgram(t, "prog1", "counter")
+	telemetryDir := t.TempDir()
+	if err := telemetry.NewDir(telemetryDir).SetModeAsOf("on", time.Now().Add(-365*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := regtest.RunProgAsOf(t, telemetryDir, time.Now().Add(-8*24*time.Hour), prog); err != nil {
+		t.Fatalf("failed to run program: %s", out)
+	}
+	cfg, getUploads := runConfig(t, telemetryDir, []string{"counter"}, nil)
+
+	for i, wantDownloads := range []int64{1, 0} {
+		downloadsBefore := configstore.Downloads()
+		if err := upload.Run(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := configstore.Downloads() - downloadsBefore; got != wantDownloads {
+			t.Errorf("run #%d: configstore.Download called: %v, want %v", i+1, got, wantDownloads)
+		}
+	}
+	if _, err := os.Stat(telemetry.NewDir(telemetryDir).ConfigCacheFile()); err != nil {
+		t.Errorf("config cache not written: %v", err)
+	}
+	if got, want := len(getUploads()), 1; got != want {
+		t.Errorf("got %d uploads, want %d", got, want)
+	}
+}
+
 func TestRun_DebugLog(t *testing.T) {
 	// This test verifies that the uploader honors the telemetry mode, as well as
 	// its asof date.
@@ -0,0 +1,153 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+// This file defines the observability of the uploader itself: the outcome
+// of each upload is counted in the telemetry/upload counters, and the
+// outcome of the last upload run is recorded in the upload/status.json
+// file, so that failing uploads are noticed even without a debug log.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/telemetry/internal/counter"
+)
+
+// Outcomes of uploads, counted in the telemetry/upload:OUTCOME counters.
+const (
+	outcomeOK       = "ok"       // the report was uploaded
+	outcomeHTTP4xx  = "http4xx"  // the server rejected the report, or refused it with a 4xx status
+	outcomeHTTP5xx  = "http5xx"  // the server failed with a 5xx status
+	outcomeNetErr   = "neterr"   // the request failed without a response, such as for a network error
+	outcomeNoConfig = "noconfig" // the upload config could not be downloaded
+	outcomeSkipped  = "skipped"  // the report was not uploaded, such as because it is dated in the future
+)
+
+// countOutcome increments the counter of the given outcome.
+func countOutcome(outcome string) {
+	counter.New("telemetry/upload:" + outcome).Inc()
+}
+
+// uploadOutcome returns the outcome of an upload that failed with err, or
+// succeeded if err is nil.
+func uploadOutcome(err error) string {
+	var serr *statusError
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.As(err, &serr) && serr.code >= 500:
+		return outcomeHTTP5xx
+	case serr != nil, errors.Is(err, ErrRejected):
+		return outcomeHTTP4xx
+	case errors.Is(err, context.Canceled):
+		return outcomeSkipped
+	default:
+		return outcomeNetErr
+	}
+}
+
+// Status is the outcome of the latest upload runs of a telemetry
+// directory, as recorded in upload/status.json.
+type Status struct {
+	LastAttempt time.Time // start time of the last run that attempted to upload
+	LastSuccess time.Time // start time of the last run that uploaded a report
+	LastError   string    `json:",omitempty"` // why the last run failed to upload, if it did
+	Config      string    // version of the upload config of the last run
+	Pending     int       // number of reports left to upload by the last run
+}
+
+// ReadStatus returns the status recorded by the last upload runs. If no
+// upload was ever attempted, the error wraps [fs.ErrNotExist].
+func ReadStatus(rcfg RunConfig) (*Status, error) {
+	u, err := newLocalUploader(rcfg)
+	if err != nil {
+		return nil, err
+	}
+	defer u.Close()
+	return u.readStatus()
+}
+
+func (u *uploader) statusFile() string {
+	return filepath.Join(u.dir.UploadDir(), "status.json")
+}
+
+func (u *uploader) readStatus() (*Status, error) {
+	data, err := os.ReadFile(u.statusFile())
+	if err != nil {
+		return nil, err
+	}
+	status := new(Status)
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// updateStatus applies update to the recorded status, starting from the
+// zero Status if there is none or it is unreadable. Failures are logged:
+// the status is informative only.
+func (u *uploader) updateStatus(update func(*Status)) {
+	status, err := u.readStatus()
+	if err != nil {
+		status = new(Status)
+	}
+	update(status)
+	data, err := json.MarshalIndent(status, "", "\t")
+	if err != nil {
+		u.logger.Printf("Failed to marshal upload status: %v", err)
+		return
+	}
+	// Write and rename, so that readers never see a partial status.
+	name := u.statusFile()
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		u.logger.Printf("Failed to write upload status: %v", err)
+		return
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		u.logger.Printf("Failed to write upload status: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		u.logger.Printf("Failed to write upload status: %v", err)
+	}
+}
+
+// recordRun records the status of a run that processed todo. The outcome
+// of the last upload attempt is left alone if the run did not try to
+// upload any report.
+func (u *uploader) recordRun(todo *work) {
+	if mode, _ := u.dir.Mode(); !UploadsEnabled(mode) {
+		return // nothing was attempted
+	}
+	pending := 0
+	for _, dw := range todo.dests {
+		for _, f := range dw.readyfiles {
+			if _, err := os.Stat(f); err == nil {
+				pending++
+			}
+		}
+	}
+	u.updateStatus(func(s *Status) {
+		if u.uploaded > 0 || u.uploadErr != nil {
+			s.LastAttempt = u.startTime
+			if u.uploaded > 0 {
+				s.LastSuccess = u.startTime
+			}
+			s.LastError = ""
+			if u.uploadErr != nil {
+				s.LastError = u.uploadErr.Error()
+			}
+		}
+		s.Config = u.dests[0].configVersion
+		s.Pending = pending
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package upload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Transport delivers reports to their destination.
+//
+// The uploader keeps track of the reports that have been delivered, and
+// retries failed deliveries, whatever the transport.
+type Transport interface {
+	// Upload delivers the JSON report for the week ending on the given
+	// date (in YYYY-MM-DD form).
+	//
+	// If the destination will never accept the report, Upload returns an
+	// error wrapping ErrRejected, and the report is discarded. Any other
+	// error is assumed to be transient, and the upload is retried later,
+	// after the delay given by a RetryAfterError, if any.
+	Upload(ctx context.Context, date string, report []byte) error
+}
+
+// ErrRejected is returned by a Transport whose destination will never
+// accept a report.
+var ErrRejected = errors.New("report rejected")
+
+// A RetryAfterError is a transient upload failure, after which the
+// destination asks to wait for the given delay before trying again.
+type RetryAfterError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", e.Err, e.Delay)
+}
+
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// A statusError is an upload failure with an unexpected HTTP status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return e.status }
+
+// An HTTPTransport uploads reports to an HTTP server, such as
+// telemetry.go.dev, by posting each report to URL/DATE as gzipped JSON.
+type HTTPTransport struct {
+	URL    string
+	Client *http.Client // if nil, http.DefaultClient is used
+}
+
+func (t *HTTPTransport) String() string { return t.URL }
+
+func (t *HTTPTransport) Upload(ctx context.Context, date string, report []byte) error {
+	body, err := compressReport(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL+"/"+date, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	serr := &statusError{resp.StatusCode, resp.Status}
+	if isRejection(resp) {
+		return fmt.Errorf("%w: %w", ErrRejected, serr)
+	}
+	// Any other status, such as a server error, or a 403 or 404 from a
+	// misconfigured proxy, may be transient.
+	err = fmt.Errorf("server returned %w", serr)
+	if delay := retryAfter(resp.Header.Get("Retry-After"), time.Now()); delay >= 0 {
+		return &RetryAfterError{delay, err}
+	}
+	return err
+}
+
+// compressReport returns the JSON report buf, compacted and gzipped for
+// uploading. Invalid JSON is left for the server to reject.
+func compressReport(buf []byte) ([]byte, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, buf); err == nil {
+		buf = compact.Bytes()
+	}
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	if _, err := zw.Write(buf); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return zbuf.Bytes(), nil
+}
+
+// isRejection reports whether the response to an upload request means
+// that the server will never accept the report: that is, whether it has
+// status 400 (Bad Request), 413 (Content Too Large) or 422 (Unprocessable
+// Content), or its body starts with "rejected".
+func isRejection(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return strings.HasPrefix(strings.TrimSpace(string(body)), "rejected")
+}
+
+// retryAfter returns the delay requested by a Retry-After header with the
+// given value, which is either a number of seconds or an HTTP date, or -1
+// if the value is empty or invalid.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return -1
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return -1
+		}
+		return time.Duration(min(secs, 1<<31)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return -1
+}
+
+// A DirTransport "uploads" reports by writing them into a spool directory,
+// for machines that cannot reach the upload server. The reports may then
+// be carried to a connected machine and uploaded from there.
+//
+// Each report is written as DATE.HASH.json, where HASH identifies its
+// contents, so that several machines may share a spool directory.
+type DirTransport struct {
+	Dir string
+}
+
+func (t *DirTransport) String() string { return t.Dir }
+
+func (t *DirTransport) Upload(ctx context.Context, date string, report []byte) error {
+	if err := os.MkdirAll(t.Dir, 0777); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(report)
+	name := filepath.Join(t.Dir, date+"."+hex.EncodeToString(sum[:8])+".json")
+	tmp, err := os.CreateTemp(t.Dir, ".report.*.tmp")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(report)
+	if err := tmp.Close(); werr == nil {
+		werr = err
+	}
+	if werr == nil {
+		werr = os.Rename(tmp.Name(), name)
+	}
+	if werr != nil {
+		os.Remove(tmp.Name())
+	}
+	return werr
+}
+
+// A MemTransport is a Transport for tests, which records the reports it
+// is given in memory.
+type MemTransport struct {
+	// Fail, if set, is called for each upload; if it returns an error,
+	// the upload fails with that error.
+	Fail func(date string) error
+
+	mu      sync.Mutex
+	reports []MemReport
+}
+
+// A MemReport is a report recorded by a MemTransport.
+type MemReport struct {
+	Date   string
+	Report []byte
+}
+
+func (t *MemTransport) String() string { return "memory" }
+
+func (t *MemTransport) Upload(ctx context.Context, date string, report []byte) error {
+	if t.Fail != nil {
+		if err := t.Fail(date); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reports = append(t.reports, MemReport{date, bytes.Clone(report)})
+	return nil
+}
+
+// Reports returns the reports uploaded so far.
+func (t *MemTransport) Reports() []MemReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]MemReport(nil), t.reports...)
+}
@@ -5,8 +5,9 @@
 package upload
 
 import (
-	"bytes"
-	"net/http"
+	"context"
+	"errors"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -38,7 +39,7 @@ func (u *uploader) uploadReportDate(fname string) time.Time {
 	return d
 }
 
-func (u *uploader) uploadReport(fname string) {
+func (u *uploader) uploadReport(ctx context.Context, d *destination, fname string) {
 	thisInstant := u.startTime
 	// TODO(rfindley): use uploadReportDate here, once we've done a gopls release.
 
@@ -49,61 +50,44 @@ func (u *uploader) uploadReport(fname string) {
 		u.logger.Printf("Report name %q missing date", filepath.Base(fname))
 	} else if match[1] > today {
 		u.logger.Printf("Report date for %q is later than today (%s)", filepath.Base(fname), today)
+		countOutcome(outcomeSkipped)
 		return // report is in the future, which shouldn't happen
 	}
 	buf, err := os.ReadFile(fname)
 	if err != nil {
 		u.logger.Printf("%v reading %s", err, fname)
+		countOutcome(outcomeSkipped)
 		return
 	}
-	if u.uploadReportContents(fname, buf) {
+	if u.uploadReportContents(ctx, d, fname, buf) {
 		// anything left to do?
 	}
 }
 
-// try to upload the report, 'true' if successful
-func (u *uploader) uploadReportContents(fname string, buf []byte) bool {
+// try to upload the report to d, 'true' if successful
+func (u *uploader) uploadReportContents(ctx context.Context, d *destination, fname string, buf []byte) bool {
 	fdate := strings.TrimSuffix(filepath.Base(fname), ".json")
 	fdate = fdate[len(fdate)-len(telemetry.DateOnly):]
 
-	newname := filepath.Join(u.dir.UploadDir(), fdate+".json")
-
-	// Lock the upload, to prevent duplicate uploads.
-	{
-		lockname := newname + ".lock"
-		lockfile, err := os.OpenFile(lockname, os.O_CREATE|os.O_EXCL, 0666)
-		if err != nil {
-			u.logger.Printf("Failed to acquire lock %s: %v", lockname, err)
-			return false
-		}
-		_ = lockfile.Close()
-		defer os.Remove(lockname)
-	}
+	newname := filepath.Join(d.uploadDir, fdate+".json")
 
+	// Duplicate uploads are prevented by the upload lock, held by [uploader.Run].
 	if _, err := os.Stat(newname); err == nil {
 		// Another process uploaded but failed to clean up (or hasn't yet cleaned
 		// up). Ensure that cleanup occurs.
 		u.logger.Printf("After acquire: report already uploaded")
 		_ = os.Remove(fname)
+		countOutcome(outcomeSkipped)
 		return false
 	}
 
-	endpoint := u.uploadServerURL + "/" + fdate
-	b := bytes.NewReader(buf)
-	resp, err := http.Post(endpoint, "application/json", b)
-	if err != nil {
-		u.logger.Printf("Error upload %s to %s: %v", filepath.Base(fname), endpoint, err)
-		return false
-	}
-	// hope for a 200, remove file on a 4xx, otherwise it will be retried by another process
-	if resp.StatusCode != 200 {
-		u.logger.Printf("Failed to upload %s to %s: %s", filepath.Base(fname), endpoint, resp.Status)
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			err := os.Remove(fname)
-			if err == nil {
-				u.logger.Printf("Removed local/%s", filepath.Base(fname))
+	if err := u.uploadWithRetries(ctx, d, filepath.Base(fname), fdate, buf); err != nil {
+		u.uploadErr = err
+		if errors.Is(err, ErrRejected) {
+			if err := os.Remove(fname); err == nil {
+				u.logger.Printf("Removed %s", fname)
 			} else {
-				u.logger.Printf("Error removing local/%s: %v", filepath.Base(fname), err)
+				u.logger.Printf("Error removing %s: %v", fname, err)
 			}
 		}
 		return false
@@ -112,6 +96,83 @@ func (u *uploader) uploadReportContents(fname string, buf []byte) bool {
 	if err := os.WriteFile(newname, buf, 0644); err == nil {
 		os.Remove(fname) // if it exists
 	}
-	u.logger.Printf("Uploaded %s to %q", fdate+".json", endpoint)
+	u.logger.Printf("Uploaded %s to %v", fdate+".json", d)
+	u.uploaded++
 	return true
 }
+
+// Retries of failed uploads; see [RunConfig.Retries].
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = 1 * time.Second
+	maxRetryDelay     = 1 * time.Minute // longer delays are left to a later run
+)
+
+// uploadWithRetries uploads the report with the given name to d, for the
+// given date, with contents buf, retrying transient failures. If the
+// destination rejected the report, the result wraps ErrRejected. The
+// outcome is counted in the telemetry/upload counters.
+func (u *uploader) uploadWithRetries(ctx context.Context, d *destination, name, date string, buf []byte) (rerr error) {
+	defer func() { countOutcome(uploadOutcome(rerr)) }()
+	for attempt := 0; ; attempt++ {
+		err := u.upload(ctx, d, date, buf)
+		if err == nil {
+			return nil
+		}
+		u.logger.Printf("Failed to upload %s to %v: %v", name, d, err)
+		if errors.Is(err, ErrRejected) {
+			return err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Don't retry a stalled upload: the time is better
+			// left to the remaining reports.
+			u.timedOut = true
+			return err
+		}
+		if ctx.Err() != nil {
+			return err // canceled
+		}
+		if attempt >= u.retries {
+			u.logger.Printf("Giving up on %s until the next upload", name)
+			return err
+		}
+		var delay time.Duration
+		var ra *RetryAfterError
+		if errors.As(err, &ra) {
+			if ra.Delay > maxRetryDelay {
+				u.logger.Printf("Asked to retry %s after %v: leaving it for the next upload", name, ra.Delay)
+				return err
+			}
+			delay = ra.Delay
+		} else {
+			// Exponential backoff, with jitter in [delay/2, delay).
+			delay = u.retryDelay << attempt
+			if delay <= 0 || delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		}
+		u.logger.Printf("Retrying %s in %v", name, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				u.timedOut = true
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// upload makes one attempt to upload the report for the given date to d,
+// with contents buf, within the request timeout.
+func (u *uploader) upload(ctx context.Context, d *destination, date string, buf []byte) error {
+	if u.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.requestTimeout)
+		defer cancel()
+	}
+	return d.transport.Upload(ctx, date, buf)
+}
@@ -10,12 +10,14 @@ import "golang.org/x/telemetry/internal/telemetry"
 //
 // The telemetry mode is a global value that controls both the local collection
 // and uploading of telemetry data. Possible mode values are:
-//   - "on":    both collection and uploading is enabled
-//   - "local": collection is enabled, but uploading is disabled
-//   - "off":   both collection and uploading are disabled
+//   - "on":     both collection and uploading is enabled
+//   - "review": collection is enabled, and reports are uploaded only after
+//     they are approved with the [gotelemetry] review command
+//   - "local":  collection is enabled, but uploading is disabled
+//   - "off":    both collection and uploading are disabled
 //
-// When mode is "on", or "local", telemetry data is written to the local file
-// system and may be inspected with the [gotelemetry] command.
+// When mode is "on", "review", or "local", telemetry data is written to the
+// local file system and may be inspected with the [gotelemetry] command.
 //
 // If an error occurs while reading the telemetry mode from the file system,
 // Mode returns the default value "local".
@@ -5,17 +5,26 @@
 package telemetry
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/telemetry/counter"
+	ic "golang.org/x/telemetry/internal/counter"
 	"golang.org/x/telemetry/internal/crashmonitor"
+	"golang.org/x/telemetry/internal/filelock"
 	"golang.org/x/telemetry/internal/telemetry"
 	"golang.org/x/telemetry/internal/upload"
 )
@@ -59,6 +68,91 @@ type Config struct {
 	// UploadURL, if set, overrides the URL used to receive uploaded reports. If
 	// unset, this URL defaults to https://telemetry.go.dev/upload.
 	UploadURL string
+
+	// UploadSpoolDir, if set, causes reports to be written to this
+	// directory instead of being uploaded, for machines that cannot reach
+	// the upload server. The reports may then be carried to a connected
+	// machine and uploaded from there. UploadURL is then ignored.
+	UploadSpoolDir string
+
+	// UploadHTTPClient, if set, is the HTTP client used to upload reports,
+	// for example to use a proxy or a custom certificate authority.
+	//
+	// A client cannot be passed to another process, so UploadHTTPClient
+	// has no effect unless InProcess is set. The sidecar uses the default
+	// client, which honors the HTTPS_PROXY and SSL_CERT_FILE environment
+	// variables.
+	UploadHTTPClient *http.Client
+
+	// UploadDestinations are destinations that receive reports in addition
+	// to the upload endpoint, such as private collection servers. Each
+	// destination receives its own reports, built from the same counters,
+	// but filtered and sampled according to its own upload configuration.
+	UploadDestinations []UploadDestination
+
+	// UploadPeriod, if set, overrides the minimum interval between upload
+	// attempts on this machine, which defaults to 24 hours. The period is
+	// shared by all programs using the same telemetry directory.
+	UploadPeriod time.Duration
+
+	// UploadTimeout, if set, overrides the limit on the total time spent
+	// uploading, which defaults to 10 minutes. UploadConfigTimeout and
+	// UploadRequestTimeout, if set, limit the download of the upload
+	// configuration and each upload request, respectively.
+	//
+	// When a timeout cuts the upload short, the remaining work is left for a
+	// later upload, and the telemetry/upload:timeout counter is incremented.
+	UploadTimeout        time.Duration
+	UploadConfigTimeout  time.Duration
+	UploadRequestTimeout time.Duration
+
+	// InProcess, if set, causes Start to run the uploader in a background
+	// goroutine of the calling process instead of re-executing the current
+	// executable as a sidecar. This is intended for programs that cannot be
+	// re-executed, such as plugins, test binaries, or programs that drop
+	// privileges.
+	//
+	// As in the sidecar, the uploader holds a lock in the local telemetry
+	// directory while it runs, so that at most one process uploads at a time.
+	//
+	// Crash reporting requires a separate process to observe the crash, so
+	// ReportCrashes has no effect when InProcess is set.
+	InProcess bool
+
+	// SidecarPath, if set, is the path of a dedicated telemetry helper
+	// executable, built from golang.org/x/telemetry/cmd/telemetrysidecar,
+	// that Start runs as the telemetry sidecar instead of re-executing the
+	// current executable. This avoids running the application's init
+	// functions a second time, and the cost of starting a large executable.
+	//
+	// The helper records counters and crashes on behalf of the application,
+	// using the application's build information. It should be built from
+	// the same version of golang.org/x/telemetry as the application. On
+	// platforms where the helper cannot read the application's symbol
+	// table, crash reporting is disabled when SidecarPath is set.
+	SidecarPath string
+}
+
+// An UploadDestination is an additional destination for reports; see
+// [Config.UploadDestinations].
+type UploadDestination struct {
+	// Name identifies the destination, whose uploads are tracked
+	// separately from those of other destinations. It consists of lower
+	// case letters, digits, '-' and '_'.
+	Name string
+
+	// URL is the endpoint that receives the reports of the destination,
+	// as https://telemetry.go.dev/upload does.
+	URL string
+
+	// ConfigFile is the path of the upload configuration of the
+	// destination, in the JSON form of the config.json file of the
+	// golang.org/x/telemetry/config module. It is read at each upload.
+	ConfigFile string
+
+	// ConfigVersion is the version of the upload configuration, which is
+	// recorded in the reports. If unset, it is v0.0.0.
+	ConfigVersion string
 }
 
 // Start initializes telemetry using the specified configuration.
@@ -83,6 +177,13 @@ type Config struct {
 // steps or external side effects in init functions, as they will
 // be executed twice (parent and child).
 //
+// If [Config.SidecarPath] is set, Start runs that executable as the
+// sidecar instead, and Start need not be called early in main.
+//
+// If [Config.InProcess] is set, Start does not re-execute the current
+// executable: uploading happens in a background goroutine of the calling
+// process, and crashes are not reported.
+//
 // Start returns a StartResult, which may be awaited via [StartResult.Wait] to
 // wait for all work done by Start to complete.
 func Start(config Config) *StartResult {
@@ -116,7 +217,30 @@ func MaybeChild(config Config) {
 // A StartResult is a handle to the result of a call to [Start]. Call
 // [StartResult.Wait] to wait for the completion of all work done on behalf of
 // Start.
+//
+// The exported fields describe what Start did, for use in diagnostics.
 type StartResult struct {
+	// Mode is the telemetry mode in effect when Start was called.
+	Mode string
+
+	// CrashMonitoring reports whether fatal crashes of this process will be
+	// reported by the sidecar.
+	CrashMonitoring bool
+
+	// UploadToken reports whether this process acquired the upload token,
+	// meaning that an upload was started on its behalf.
+	UploadToken bool
+
+	// SidecarStarted reports whether the telemetry sidecar process was
+	// started, and SidecarPID holds its process ID.
+	SidecarStarted bool
+	SidecarPID     int
+
+	// Err records why Start could not do the work requested by its Config,
+	// if anything prevented it. Errors that occur later, in the sidecar or
+	// in the background, are not reported.
+	Err error
+
 	wg sync.WaitGroup
 }
 
@@ -141,6 +265,15 @@ const telemetryChildVar = "GO_TELEMETRY_CHILD"
 // acquired by the parent, and the child should attempt an upload.
 const telemetryUploadVar = "GO_TELEMETRY_CHILD_UPLOAD"
 
+// If telemetryExeVar is set in the environment, the child is a dedicated
+// sidecar executable (see [Config.SidecarPath]), and the variable holds the
+// path of the parent's executable. In that case telemetryBuildInfoVar holds
+// the parent's build information, as formatted by [debug.BuildInfo.String].
+const (
+	telemetryExeVar       = "GO_TELEMETRY_CHILD_EXE"
+	telemetryBuildInfoVar = "GO_TELEMETRY_CHILD_BUILDINFO"
+)
+
 func parent(config Config) *StartResult {
 	if config.TelemetryDir != "" {
 		telemetry.Default = telemetry.NewDir(config.TelemetryDir)
@@ -148,9 +281,10 @@ func parent(config Config) *StartResult {
 	result := new(StartResult)
 
 	mode, _ := telemetry.Default.Mode()
+	result.Mode = mode
 	if mode == "off" {
 		// Telemetry is turned off. Crash reporting doesn't work without telemetry
-		// at least set to "local". The upload process runs in both "on" and "local" modes.
+		// at least set to "local". The upload process runs in the "on", "review" and "local" modes.
 		// In local mode the upload process builds local reports but does not do the upload.
 		return result
 	}
@@ -162,20 +296,38 @@ func parent(config Config) *StartResult {
 		// crash monitoring and counter uploading. Most likely, there was an
 		// error creating telemetry.LocalDir in the counter.Open call above.
 		// Don't start the child.
+		result.Err = fmt.Errorf("telemetry local directory is unavailable: %w", err)
+		return result
+	}
+	if telemetry.Default.ReadOnly() {
+		// The directory was written by a newer version of this library.
+		result.Err = telemetry.ErrNewerLayout
 		return result
 	}
 
-	childShouldUpload := config.Upload && acquireUploadToken()
-	reportCrashes := config.ReportCrashes && crashmonitor.Supported()
+	if config.Upload {
+		result.UploadToken, result.Err = acquireUploadToken(config.UploadPeriod)
+	}
+
+	if config.InProcess {
+		if result.UploadToken {
+			startInProcess(config, result)
+		}
+		return result
+	}
+
+	childShouldUpload := result.UploadToken
+	reportCrashes := config.ReportCrashes && crashmonitor.Supported() &&
+		(config.SidecarPath == "" || crashmonitor.ExecutableSupported())
 
 	if reportCrashes || childShouldUpload {
-		startChild(reportCrashes, childShouldUpload, result)
+		startChild(config, reportCrashes, childShouldUpload, result)
 	}
 
 	return result
 }
 
-func startChild(reportCrashes, upload bool, result *StartResult) {
+func startChild(config Config, reportCrashes, upload bool, result *StartResult) {
 	// This process is the application (parent).
 	// Fork+exec the telemetry child.
 	exe, err := os.Executable()
@@ -183,15 +335,26 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 		// There was an error getting os.Executable. It's possible
 		// for this to happen on AIX if os.Args[0] is not an absolute
 		// path and we can't find os.Args[0] in PATH.
-		log.Printf("failed to start telemetry sidecar: os.Executable: %v", err)
+		result.Err = fmt.Errorf("failed to start telemetry sidecar: os.Executable: %w", err)
 		return
 	}
-	cmd := exec.Command(exe, "** telemetry **") // this unused arg is just for ps(1)
+	var cmd *exec.Cmd
+	if config.SidecarPath != "" {
+		cmd = exec.Command(config.SidecarPath, sidecarArgs(config, reportCrashes)...)
+	} else {
+		cmd = exec.Command(exe, "** telemetry **") // this unused arg is just for ps(1)
+	}
 	daemonize(cmd)
 	cmd.Env = append(os.Environ(), telemetryChildVar+"=1")
 	if upload {
 		cmd.Env = append(cmd.Env, telemetryUploadVar+"=1")
 	}
+	if config.SidecarPath != "" {
+		cmd.Env = append(cmd.Env, telemetryExeVar+"="+exe)
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			cmd.Env = append(cmd.Env, telemetryBuildInfoVar+"="+bi.String())
+		}
+	}
 	cmd.Dir = telemetry.Default.LocalDir()
 
 	// The child process must write to a log file, not
@@ -206,7 +369,7 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 	fd, err := os.Stat(telemetry.Default.DebugDir())
 	if err != nil {
 		if !os.IsNotExist(err) {
-			log.Printf("failed to stat debug directory: %v", err)
+			result.Err = fmt.Errorf("failed to stat debug directory: %w", err)
 			return
 		}
 	} else if fd.IsDir() {
@@ -215,7 +378,7 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 		childLogPath := filepath.Join(telemetry.Default.DebugDir(), "sidecar.log")
 		childLog, err := os.OpenFile(childLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 		if err != nil {
-			log.Printf("opening sidecar log file for child: %v", err)
+			result.Err = fmt.Errorf("opening sidecar log file for child: %w", err)
 			return
 		}
 		defer childLog.Close()
@@ -226,7 +389,7 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 	if reportCrashes {
 		pipe, err := cmd.StdinPipe()
 		if err != nil {
-			log.Printf("StdinPipe: %v", err)
+			result.Err = fmt.Errorf("StdinPipe: %w", err)
 			return
 		}
 
@@ -234,12 +397,15 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 	}
 
 	if err := cmd.Start(); err != nil {
-		// The child couldn't be started. Log the failure.
-		log.Printf("can't start telemetry child process: %v", err)
+		// The child couldn't be started. Record the failure.
+		result.Err = fmt.Errorf("can't start telemetry child process: %w", err)
 		return
 	}
+	result.SidecarStarted = true
+	result.SidecarPID = cmd.Process.Pid
 	if reportCrashes {
 		crashmonitor.Parent(crashOutputFile)
+		result.CrashMonitoring = true
 	}
 	result.wg.Add(1)
 	go func() {
@@ -248,6 +414,64 @@ func startChild(reportCrashes, upload bool, result *StartResult) {
 	}()
 }
 
+// startInProcess runs the uploader in a goroutine of the current process.
+func startInProcess(config Config, result *StartResult) {
+	result.wg.Add(1)
+	go func() {
+		defer result.wg.Done()
+
+		// As in the sidecar (see golang/go#67211), ensure that go commands run to
+		// download the upload config do not consider themselves telemetry
+		// children. Unlike the sidecar, we must not modify the environment of
+		// the application, so the variable is passed to the uploader instead.
+		//
+		// Errors are not reported to the application: the uploader records
+		// them in the debug log, if the debug directory exists.
+		rc := uploadRunConfig(config)
+		rc.Env = []string{telemetryChildVar + "=2"}
+		recordUploadTimeout(upload.Run(rc))
+	}()
+}
+
+// sidecarArgs returns the command-line arguments of a dedicated sidecar
+// executable, which must be given the configuration that a re-executed
+// child would obtain from its own call to Start.
+func sidecarArgs(config Config, reportCrashes bool) []string {
+	args := []string{"-dir=" + telemetry.Default.Dir()}
+	if config.UploadURL != "" {
+		args = append(args, "-upload-url="+config.UploadURL)
+	}
+	if config.UploadSpoolDir != "" {
+		args = append(args, "-upload-spool-dir="+config.UploadSpoolDir)
+	}
+	if len(config.UploadDestinations) > 0 {
+		dests, err := json.Marshal(config.UploadDestinations)
+		if err != nil {
+			panic(err) // a slice of structs of strings is always encodable
+		}
+		args = append(args, "-upload-destinations="+string(dests))
+	}
+	if !config.UploadStartTime.IsZero() {
+		args = append(args, "-upload-start="+config.UploadStartTime.Format(time.RFC3339))
+	}
+	for _, d := range []struct {
+		flag string
+		d    time.Duration
+	}{
+		{"upload-timeout", config.UploadTimeout},
+		{"upload-config-timeout", config.UploadConfigTimeout},
+		{"upload-request-timeout", config.UploadRequestTimeout},
+	} {
+		if d.d != 0 {
+			args = append(args, fmt.Sprintf("-%s=%v", d.flag, d.d))
+		}
+	}
+	if reportCrashes {
+		args = append(args, "-crashes")
+	}
+	return args
+}
+
 func child(config Config) {
 	log.SetPrefix(fmt.Sprintf("telemetry-sidecar (pid %v): ", os.Getpid()))
 
@@ -255,6 +479,18 @@ func child(config Config) {
 		telemetry.Default = telemetry.NewDir(config.TelemetryDir)
 	}
 
+	// A dedicated sidecar executable records counters and crashes on behalf
+	// of its parent, so it must use the parent's identity.
+	parentExe := os.Getenv(telemetryExeVar)
+	if parentExe != "" {
+		bi, err := debug.ParseBuildInfo(os.Getenv(telemetryBuildInfoVar))
+		if err != nil {
+			log.Printf("invalid parent build info: %v", err)
+		} else {
+			ic.SetBuildInfo(bi)
+		}
+	}
+
 	// golang/go#67211: be sure to set telemetryChildVar before running the
 	// child, because the child itself invokes the go command to download the
 	// upload config. If the telemetryChildVar variable is still set to "1",
@@ -268,8 +504,6 @@ func child(config Config) {
 	upload := os.Getenv(telemetryUploadVar) == "1"
 
 	reportCrashes := config.ReportCrashes && crashmonitor.Supported()
-	uploadStartTime := config.UploadStartTime
-	uploadURL := config.UploadURL
 
 	// The crashmonitor and/or upload process may themselves record counters.
 	counter.Open()
@@ -282,13 +516,17 @@ func child(config Config) {
 
 	if reportCrashes {
 		g.Go(func() error {
-			crashmonitor.Child()
+			if parentExe != "" {
+				crashmonitor.ChildForExecutable(parentExe)
+			} else {
+				crashmonitor.Child()
+			}
 			return nil
 		})
 	}
 	if upload {
 		g.Go(func() error {
-			uploaderChild(uploadStartTime, uploadURL)
+			uploaderChild(config)
 			return nil
 		})
 	}
@@ -297,62 +535,154 @@ func child(config Config) {
 	os.Exit(0)
 }
 
-func uploaderChild(asof time.Time, uploadURL string) {
-	if err := upload.Run(upload.RunConfig{
-		UploadURL: uploadURL,
-		LogWriter: os.Stderr,
-		StartTime: asof,
-	}); err != nil {
+func uploaderChild(config Config) {
+	rc := uploadRunConfig(config)
+	rc.LogWriter = os.Stderr
+	if err := upload.Run(rc); err != nil {
 		log.Printf("upload failed: %v", err)
+		recordUploadTimeout(err)
+	}
+}
+
+// defaultUploadTimeout is the default limit on the time spent uploading; see
+// [Config.UploadTimeout].
+const defaultUploadTimeout = 10 * time.Minute
+
+// uploadRunConfig returns the configuration of the uploader for config.
+func uploadRunConfig(config Config) upload.RunConfig {
+	timeout := config.UploadTimeout
+	if timeout <= 0 {
+		timeout = defaultUploadTimeout
+	}
+	rc := upload.RunConfig{
+		UploadURL:      config.UploadURL,
+		StartTime:      config.UploadStartTime,
+		Timeout:        timeout,
+		ConfigTimeout:  config.UploadConfigTimeout,
+		RequestTimeout: config.UploadRequestTimeout,
+	}
+	switch {
+	case config.UploadSpoolDir != "":
+		rc.Transport = &upload.DirTransport{Dir: config.UploadSpoolDir}
+	case config.UploadHTTPClient != nil && config.InProcess:
+		url := config.UploadURL
+		if url == "" {
+			url = upload.DefaultUploadURL
+		}
+		rc.Transport = &upload.HTTPTransport{URL: url, Client: config.UploadHTTPClient}
 	}
+	for _, d := range config.UploadDestinations {
+		transport := &upload.HTTPTransport{URL: d.URL}
+		if config.InProcess {
+			transport.Client = config.UploadHTTPClient
+		}
+		rc.Destinations = append(rc.Destinations, upload.Destination{
+			Name:      d.Name,
+			Transport: transport,
+			Config:    d.readConfig,
+		})
+	}
+	return rc
 }
 
+// readConfig reads the upload config of the destination.
+func (d UploadDestination) readConfig(context.Context) (*telemetry.UploadConfig, string, error) {
+	data, err := os.ReadFile(d.ConfigFile)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg := new(telemetry.UploadConfig)
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, "", fmt.Errorf("invalid upload config %s: %v", d.ConfigFile, err)
+	}
+	version := d.ConfigVersion
+	if version == "" {
+		version = "v0.0.0"
+	}
+	return cfg, version, nil
+}
+
+// recordUploadTimeout increments the telemetry/upload:timeout counter if err,
+// the result of an upload, indicates that a timeout cut the upload short.
+func recordUploadTimeout(err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		counter.Inc("telemetry/upload:timeout")
+	}
+}
+
+// defaultUploadPeriod is the default minimum interval between uploads from
+// this machine; see [Config.UploadPeriod].
+const defaultUploadPeriod = 24 * time.Hour
+
 // acquireUploadToken acquires a token permitting the caller to upload.
-// To limit the frequency of uploads, only one token is issue per
-// machine per time period.
+// To limit the frequency of uploads, only one token is issued per
+// machine per period.
 // The boolean indicates whether the token was acquired.
-func acquireUploadToken() bool {
+func acquireUploadToken(period time.Duration) (bool, error) {
 	if telemetry.Default.LocalDir() == "" {
 		// The telemetry dir wasn't initialized properly, probably because
 		// os.UserConfigDir did not complete successfully. In that case
 		// there are no counters to upload, so we should just do nothing.
-		return false
+		return false, nil
+	}
+	if period <= 0 {
+		period = defaultUploadPeriod
 	}
 	tokenfile := filepath.Join(telemetry.Default.LocalDir(), "upload.token")
-	const period = 24 * time.Hour
-
-	// A process acquires a token by successfully creating a
-	// well-known file. If the file already exists and has an
-	// mtime age less then than the period, the process does
-	// not acquire the token. If the file is older than the
-	// period, the process is allowed to remove the file and
-	// try to re-create it.
-	fi, err := os.Stat(tokenfile)
-	if err == nil {
-		if time.Since(fi.ModTime()) < period {
-			return false
+
+	// The token file records the process ID of the last holder of the token,
+	// and the time at which it was acquired. A process acquires the token by
+	// locking the file, and then replacing its contents if the last
+	// acquisition is older than the period.
+	_, statErr := os.Stat(tokenfile)
+	legacy := statErr == nil // an empty file that we did not create
+	f, err := os.OpenFile(tokenfile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return false, fmt.Errorf("error acquiring upload token: opening token file: %w", err)
+	}
+	defer f.Close()
+	if ok, err := filelock.TryLock(f); !ok {
+		// Another process is acquiring the token right now.
+		if err != nil {
+			return false, fmt.Errorf("error acquiring upload token: %w", err)
 		}
-		// There's a possible race here where two processes check the
-		// token file and see that it's older than the period, then the
-		// first one removes it and creates another, and then a second one
-		// removes the newly created file and creates yet another
-		// file. Then both processes would act as though they had the token.
-		// This is very rare, but it's also okay because we're only grabbing
-		// the token to do rate limiting, not for correctness.
-		_ = os.Remove(tokenfile)
-	} else if !os.IsNotExist(err) {
-		log.Printf("error acquiring upload taken: statting token file: %v", err)
-		return false
-	}
-
-	f, err := os.OpenFile(tokenfile, os.O_CREATE|os.O_EXCL, 0666)
+		return false, nil
+	}
+	defer filelock.Unlock(f)
+
+	now := time.Now()
+	if last, ok := readUploadToken(f, legacy); ok && now.Sub(last) < period {
+		return false, nil
+	}
+	token := fmt.Sprintf("%d %s\n", os.Getpid(), now.UTC().Format(time.RFC3339))
+	if err := f.Truncate(0); err != nil {
+		return false, fmt.Errorf("error acquiring upload token: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(token), 0); err != nil {
+		return false, fmt.Errorf("error acquiring upload token: %w", err)
+	}
+	return true, nil
+}
+
+// readUploadToken returns the time at which the upload token in f was last
+// acquired, if known. If legacy is set, an empty f is assumed to have been
+// created by an older version of this package.
+func readUploadToken(f *os.File, legacy bool) (time.Time, bool) {
+	data, err := io.ReadAll(f)
 	if err != nil {
-		if os.IsExist(err) {
-			return false
+		return time.Time{}, false
+	}
+	if _, ts, ok := strings.Cut(strings.TrimSpace(string(data)), " "); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return t, true
+		}
+	}
+	// Older versions of this package created an empty token file, and
+	// acquired the token by re-creating it: use its modification time.
+	if legacy && len(data) == 0 {
+		if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+			return fi.ModTime(), true
 		}
-		log.Printf("error acquiring upload token: creating token file: %v", err)
-		return false
 	}
-	_ = f.Close()
-	return true
+	return time.Time{}, false
 }
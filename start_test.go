@@ -5,12 +5,15 @@
 package telemetry_test
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -22,6 +25,7 @@ import (
 	"golang.org/x/telemetry/internal/configtest"
 	ic "golang.org/x/telemetry/internal/counter"
 	"golang.org/x/telemetry/internal/crashmonitor"
+	"golang.org/x/telemetry/internal/filelock"
 	"golang.org/x/telemetry/internal/regtest"
 	it "golang.org/x/telemetry/internal/telemetry"
 	"golang.org/x/telemetry/internal/testenv"
@@ -34,8 +38,27 @@ const (
 	telemetryDirEnv = "X_TELEMETRY_TEST_START_TELEMETRY_DIR"
 	uploadURLEnv    = "X_TELEMETRY_TEST_START_UPLOAD_URL"
 	asofEnv         = "X_TELEMETRY_TEST_START_ASOF"
+	sidecarEnv      = "X_TELEMETRY_TEST_START_SIDECAR"
+	wantModeEnv     = "X_TELEMETRY_TEST_START_WANT_MODE"
+	spoolDirEnv     = "X_TELEMETRY_TEST_START_SPOOL_DIR"
+	destURLEnv      = "X_TELEMETRY_TEST_START_DEST_URL"
+	destConfigEnv   = "X_TELEMETRY_TEST_START_DEST_CONFIG"
 )
 
+// clientHeader marks the upload requests sent by the HTTP client of
+// Config.UploadHTTPClient; see taggingTransport.
+const clientHeader = "X-Telemetry-Test-Client"
+
+// taggingTransport is an HTTP transport that marks the requests it sends
+// with clientHeader.
+type taggingTransport struct{}
+
+func (taggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(clientHeader, "1")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 func TestMain(m *testing.M) {
 	// TestStart can't use internal/regtest, because Start itself also uses
 	// fork+exec to start a subprocess, which does not interact well with the
@@ -99,6 +122,82 @@ func runProg(prog string) int {
 		})
 		res.Wait()
 
+	case "crash-sidecar":
+		telemetry.Start(telemetry.Config{
+			TelemetryDir:  telemetryDir,
+			ReportCrashes: true,
+			SidecarPath:   mustGetEnv(sidecarEnv),
+		})
+		panic("crash!")
+
+	case "result":
+		res := telemetry.Start(telemetry.Config{
+			TelemetryDir:  telemetryDir,
+			ReportCrashes: true,
+		})
+		wantMode := mustGetEnv(wantModeEnv)
+		if res.Mode != wantMode {
+			log.Fatalf("StartResult.Mode = %q, want %q", res.Mode, wantMode)
+		}
+		if res.Err != nil {
+			log.Fatalf("StartResult.Err = %v", res.Err)
+		}
+		monitoring := wantMode != "off" && crashmonitor.Supported()
+		if res.CrashMonitoring != monitoring || res.SidecarStarted != monitoring || (res.SidecarPID != 0) != monitoring {
+			log.Fatalf("StartResult = %+v, want crash monitoring and sidecar = %t", res, monitoring)
+		}
+		if res.UploadToken {
+			log.Fatalf("StartResult.UploadToken = true without Config.Upload")
+		}
+
+	case "upload-timeout":
+		res := telemetry.Start(telemetry.Config{
+			TelemetryDir:         telemetryDir,
+			Upload:               true,
+			UploadURL:            mustGetEnv(uploadURLEnv),
+			UploadStartTime:      asof,
+			UploadRequestTimeout: 100 * time.Millisecond,
+		})
+		res.Wait()
+
+	case "upload-inprocess":
+		res := telemetry.Start(telemetry.Config{
+			TelemetryDir:     telemetryDir,
+			Upload:           true,
+			UploadURL:        mustGetEnv(uploadURLEnv),
+			UploadStartTime:  asof,
+			UploadHTTPClient: &http.Client{Transport: taggingTransport{}},
+			InProcess:        true,
+		})
+		res.Wait()
+		if os.Getenv("GO_TELEMETRY_CHILD") != "" {
+			log.Fatalf("in-process Start modified the environment")
+		}
+
+	case "upload-spool":
+		res := telemetry.Start(telemetry.Config{
+			TelemetryDir:    telemetryDir,
+			Upload:          true,
+			UploadSpoolDir:  mustGetEnv(spoolDirEnv),
+			UploadStartTime: asof,
+		})
+		res.Wait()
+
+	case "upload-destinations":
+		res := telemetry.Start(telemetry.Config{
+			TelemetryDir:    telemetryDir,
+			Upload:          true,
+			UploadURL:       mustGetEnv(uploadURLEnv),
+			UploadStartTime: asof,
+			UploadDestinations: []telemetry.UploadDestination{{
+				Name:          "internal",
+				URL:           mustGetEnv(destURLEnv),
+				ConfigFile:    mustGetEnv(destConfigEnv),
+				ConfigVersion: "v9.9.9",
+			}},
+		})
+		res.Wait()
+
 	default:
 		log.Fatalf("unknown program %q", prog)
 	}
@@ -147,7 +246,7 @@ func TestStart(t *testing.T) {
 	uploaded := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		uploaded = true
-		body, err := io.ReadAll(r.Body)
+		body, err := readUpload(r)
 		if err != nil {
 			t.Errorf("error reading body: %v", err)
 		} else {
@@ -177,6 +276,306 @@ func TestStart(t *testing.T) {
 	}
 }
 
+func TestStartResult(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	for _, mode := range []string{"on", "local", "off"} {
+		t.Run(mode, func(t *testing.T) {
+			telemetryDir := t.TempDir()
+			if err := it.NewDir(telemetryDir).SetMode(mode); err != nil {
+				t.Fatal(err)
+			}
+			execProg(t, telemetryDir, "result", time.Now(), false, wantModeEnv+"="+mode)
+		})
+	}
+}
+
+func TestStartSidecar(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+	testenv.NeedsGo(t)
+
+	if !crashmonitor.Supported() || !crashmonitor.ExecutableSupported() {
+		t.Skip("crash reporting through a sidecar executable is not supported")
+	}
+
+	sidecar := filepath.Join(t.TempDir(), "telemetrysidecar")
+	if out, err := exec.Command("go", "build", "-o", sidecar, "golang.org/x/telemetry/cmd/telemetrysidecar").CombinedOutput(); err != nil {
+		t.Fatalf("building sidecar failed: %v\n%s", err, out)
+	}
+
+	telemetryDir := t.TempDir()
+	execProg(t, telemetryDir, "crash-sidecar", time.Now(), true, sidecarEnv+"="+sidecar)
+
+	// The sidecar records the crash asynchronously, in a counter file named
+	// for this (the parent) program.
+	_, progPath, _ := regtest.ProgramInfo(t)
+	localDir := it.NewDir(telemetryDir).LocalDir()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if name, prog := findCrashCounter(t, localDir); name != "" {
+			if prog != progPath {
+				t.Errorf("crash recorded for program %q, want %q", prog, progPath)
+			}
+			if stack := ic.DecodeStack(name); !strings.Contains(stack, "golang.org/x/telemetry_test.runProg") {
+				t.Errorf("crash stack does not contain runProg:\n%s", stack)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sidecar did not record the crash in a timely manner")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// findCrashCounter returns the name of a crash counter in the count files of
+// localDir, and the program for which it was recorded.
+func findCrashCounter(t *testing.T, localDir string) (name, program string) {
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return "", ""
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".count") {
+			continue
+		}
+		fname := filepath.Join(localDir, e.Name())
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ic.Parse(fname, data)
+		if err != nil {
+			continue // may be partially written
+		}
+		for k := range f.Count {
+			if strings.HasPrefix(k, "crash/crash\n") {
+				return k, f.Meta["Program"]
+			}
+		}
+	}
+	return "", ""
+}
+
+func TestStartUploadTimeout(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	telemetryDir := t.TempDir()
+
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stall
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(stall) }) // runs before server.Close
+	uploadEnv := []string{uploadURLEnv + "=" + server.URL}
+
+	uc := regtest.CreateTestUploadConfig(t, []string{"teststart/counter"}, nil)
+	uploadEnv = append(uploadEnv, configtest.LocalProxyEnv(t, uc, "v1.2.3")...)
+
+	now := time.Now()
+	execProg(t, telemetryDir, "setmode", now.Add(-30*24*time.Hour), false)
+	execProg(t, telemetryDir, "inc", now.Add(-8*24*time.Hour), false)
+	execProg(t, telemetryDir, "upload-timeout", now, false, uploadEnv...)
+
+	// The sidecar records the timeout in its own counter file.
+	localDir := it.NewDir(telemetryDir).LocalDir()
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".count") {
+			continue
+		}
+		fname := filepath.Join(localDir, e.Name())
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := ic.Parse(fname, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Count["telemetry/upload:timeout"] > 0 {
+			return
+		}
+	}
+	t.Errorf("no telemetry/upload:timeout counter in %s", localDir)
+}
+
+func TestStartInProcess(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	telemetryDir := t.TempDir()
+
+	var uploadMu sync.Mutex
+	var uploads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := readUpload(r)
+		if err != nil {
+			t.Errorf("error reading body: %v", err)
+			return
+		}
+		if r.Header.Get(clientHeader) == "" {
+			t.Errorf("upload was not sent by Config.UploadHTTPClient")
+		}
+		uploadMu.Lock()
+		uploads = append(uploads, string(body))
+		uploadMu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	uploadEnv := []string{uploadURLEnv + "=" + server.URL}
+
+	uc := regtest.CreateTestUploadConfig(t, []string{"teststart/counter"}, nil)
+	uploadEnv = append(uploadEnv, configtest.LocalProxyEnv(t, uc, "v1.2.3")...)
+
+	now := time.Now()
+	execProg(t, telemetryDir, "setmode", now.Add(-30*24*time.Hour), false)
+	execProg(t, telemetryDir, "inc", now.Add(-8*24*time.Hour), false)
+	execProg(t, telemetryDir, "upload-inprocess", now, false, uploadEnv...)
+
+	uploadMu.Lock()
+	defer uploadMu.Unlock()
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploads, want 1", len(uploads))
+	}
+	if !strings.Contains(uploads[0], "teststart/counter") {
+		t.Errorf("upload does not contain \"teststart/counter\":\n%s", uploads[0])
+	}
+	// The upload lock must be released once Start's work is done.
+	localDir := it.NewDir(telemetryDir).LocalDir()
+	f, err := os.Open(filepath.Join(localDir, "upload.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ok, err := filelock.TryLock(f); !ok {
+		t.Errorf("upload lock not released: %v", err)
+	}
+	// The upload token records its holder and the time it was acquired.
+	token, err := os.ReadFile(filepath.Join(localDir, "upload.token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, ts, ok := strings.Cut(strings.TrimSpace(string(token)), " "); !ok || pid == "" {
+		t.Errorf("malformed upload token %q", token)
+	} else if _, err := time.Parse(time.RFC3339, ts); err != nil {
+		t.Errorf("malformed upload token %q: %v", token, err)
+	}
+}
+
+// TestStartUploadSpool checks that Config.UploadSpoolDir makes the
+// uploader write reports to the spool directory instead of uploading them.
+func TestStartUploadSpool(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	telemetryDir := t.TempDir()
+	spoolDir := filepath.Join(t.TempDir(), "spool")
+	uc := regtest.CreateTestUploadConfig(t, []string{"teststart/counter"}, nil)
+	env := append(configtest.LocalProxyEnv(t, uc, "v1.2.3"), spoolDirEnv+"="+spoolDir)
+
+	now := time.Now()
+	execProg(t, telemetryDir, "setmode", now.Add(-30*24*time.Hour), false)
+	execProg(t, telemetryDir, "inc", now.Add(-8*24*time.Hour), false)
+	execProg(t, telemetryDir, "upload-spool", now, false, env...)
+
+	reports, err := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("got spooled reports %v (err=%v), want 1", reports, err)
+	}
+	data, err := os.ReadFile(reports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "teststart/counter") {
+		t.Errorf("spooled report does not contain \"teststart/counter\":\n%s", data)
+	}
+	// The spooled report is recorded as uploaded.
+	uploaded, err := filepath.Glob(filepath.Join(it.NewDir(telemetryDir).UploadDir(), "*-*-*.json"))
+	if err != nil || len(uploaded) != 1 {
+		t.Errorf("got uploaded reports %v (err=%v), want 1", uploaded, err)
+	}
+}
+
+// TestStartUploadDestinations checks that the uploader started by Start
+// uploads reports to each of Config.UploadDestinations, built with the
+// destination's own upload config, as well as to the upload endpoint.
+func TestStartUploadDestinations(t *testing.T) {
+	testenv.SkipIfUnsupportedPlatform(t)
+	testenv.MustHaveExec(t)
+
+	// reportServer returns the URL of a server that records the reports
+	// uploaded to it.
+	var mu sync.Mutex
+	reports := make(map[string][]*it.Report) // by server
+	reportServer := func(name string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := readUpload(r)
+			if err != nil {
+				t.Errorf("error reading body: %v", err)
+				return
+			}
+			report := new(it.Report)
+			if err := json.Unmarshal(body, report); err != nil {
+				t.Errorf("invalid report uploaded to %s: %v", name, err)
+				return
+			}
+			mu.Lock()
+			reports[name] = append(reports[name], report)
+			mu.Unlock()
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+
+	uc := regtest.CreateTestUploadConfig(t, []string{"teststart/counter"}, nil)
+	destConfig := filepath.Join(t.TempDir(), "config.json")
+	data, err := json.Marshal(uc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(destConfig, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	env := append(configtest.LocalProxyEnv(t, uc, "v1.2.3"),
+		uploadURLEnv+"="+reportServer("endpoint"),
+		destURLEnv+"="+reportServer("internal"),
+		destConfigEnv+"="+destConfig)
+
+	telemetryDir := t.TempDir()
+	now := time.Now()
+	execProg(t, telemetryDir, "setmode", now.Add(-30*24*time.Hour), false)
+	execProg(t, telemetryDir, "inc", now.Add(-8*24*time.Hour), false)
+	execProg(t, telemetryDir, "upload-destinations", now, false, env...)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for server, wantConfig := range map[string]string{"endpoint": "v1.2.3", "internal": "v9.9.9"} {
+		got := reports[server]
+		if len(got) != 1 {
+			t.Errorf("%s: got %d reports, want 1", server, len(got))
+			continue
+		}
+		if got[0].Config != wantConfig {
+			t.Errorf("%s: got report with config %s, want %s", server, got[0].Config, wantConfig)
+		}
+		if len(got[0].Programs) != 1 || got[0].Programs[0].Counters["teststart/counter"] != 1 {
+			t.Errorf("%s: got report programs %+v, want one with teststart/counter=1", server, got[0].Programs)
+		}
+	}
+	// The destination's uploads are tracked separately.
+	uploaded, err := filepath.Glob(filepath.Join(it.NewDir(telemetryDir).UploadDir(), "dest", "internal", "*.json"))
+	if err != nil || len(uploaded) != 1 {
+		t.Errorf("got uploaded reports %v for the destination (err=%v), want 1", uploaded, err)
+	}
+}
+
 func TestConcurrentStart(t *testing.T) {
 	testenv.SkipIfUnsupportedPlatform(t)
 	testenv.MustHaveExec(t)
@@ -229,3 +628,13 @@ func TestConcurrentStart(t *testing.T) {
 		}
 	}
 }
+
+// readUpload returns the report uploaded by the request r, which the
+// uploader compresses with gzip.
+func readUpload(r *http.Request) ([]byte, error) {
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}